@@ -1,57 +1,237 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"time"
 
 	"github.com/dewaka/license_gen/lib"
 )
 
+// Exit codes. These are part of the lcheck interface and must not change.
+const (
+	exitOK           = 0
+	exitFailure      = 1 // any failure not listed below
+	exitUsage        = 2
+	exitReadError    = 3 // the license, public key or revocation list could not be read
+	exitBadSignature = 4
+	exitExpired      = 5
+	exitNotYetValid  = 6
+	exitRevoked      = 7
+	exitWrongProduct = 8
+)
+
+// exitCodes maps validation codes to exit codes. Codes not listed exit with
+// exitFailure.
+var exitCodes = map[string]int{
+	lib.CodeReadError:    exitReadError,
+	lib.CodeBadSignature: exitBadSignature,
+	lib.CodeExpired:      exitExpired,
+	lib.CodeNotYetValid:  exitNotYetValid,
+	lib.CodeRevoked:      exitRevoked,
+	lib.CodeWrongProduct: exitWrongProduct,
+}
+
 var (
-	licFile = flag.String("lic", "license.json", "License file name. Required for license generation.")
-	certKey = flag.String("cert", "cert.pem", "Public certificate key.")
-	verbose = flag.Bool("verbose", false, "Print verbose messages")
+	licFile     = flag.String("lic", "", "License file. When empty the license is searched for in LICENSE_KEY, $XDG_CONFIG_HOME/<product>/license, /etc/<product>/license, the newest *.lic file in -lic-dir and license.json in the working directory, in that order.")
+	licDir      = flag.String("lic-dir", "", "Directory of *.lic license files to search")
+	certKey     = flag.String("cert", "cert.pem", "Public certificate key. COSE licenses are checked with the Ed25519 product public key.")
+	crlFile     = flag.String("crl", "", "Revocation list file or URL. Revocation is not checked when empty.")
+	crlCert     = flag.String("crl-cert", "", "Public key the revocation list must be signed with. Defaults to the key named by the list, which must be -cert.")
+	crlMinSeq   = flag.Uint64("crl-min-seq", 0, "Reject revocation lists with a lower sequence number")
+	crlState    = flag.String("crl-state", "", "File keeping the sequence of the last revocation list seen. Older lists are rejected.")
+	product     = flag.String("product", "", "Product the license must be for. Not checked when empty.")
+	fingerprint = flag.String("fingerprint", "", "Fingerprint of the key the license must be signed with, as printed by lgen inspect")
+	warnDays    = flag.Int("warn-days", 30, "Warn when the license expires within this many days")
+	format      = flag.String("format", "text", "Output format: text or json")
+	verbose     = flag.Bool("verbose", false, "Print verbose messages")
 )
 
+// result - The outcome of a check as printed by -format json
+type result struct {
+	Status        string           `json:"status"`
+	Reason        string           `json:"reason"`
+	Error         string           `json:"error,omitempty"`
+	Source        string           `json:"source,omitempty"`
+	ExitCode      int              `json:"exit_code"`
+	License       *lib.LicenseInfo `json:"license,omitempty"`
+	DaysRemaining *int             `json:"days_remaining,omitempty"`
+	Warnings      []string         `json:"warnings"`
+	Problems      []problem        `json:"problems"`
+}
+
+// problem - A single validation failure in the -format json output
+type problem struct {
+	Code  string `json:"code"`
+	Field string `json:"field,omitempty"`
+	Error string `json:"error"`
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: lcheck [flags]\n\nFlags:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(out, "\nExit codes:\n")
+	fmt.Fprintf(out, "  %d  license OK\n", exitOK)
+	fmt.Fprintf(out, "  %d  other failure\n", exitFailure)
+	fmt.Fprintf(out, "  %d  invalid usage\n", exitUsage)
+	fmt.Fprintf(out, "  %d  license, public key or revocation list could not be read\n", exitReadError)
+	fmt.Fprintf(out, "  %d  bad signature\n", exitBadSignature)
+	fmt.Fprintf(out, "  %d  license expired\n", exitExpired)
+	fmt.Fprintf(out, "  %d  license not yet valid\n", exitNotYetValid)
+	fmt.Fprintf(out, "  %d  license revoked\n", exitRevoked)
+	fmt.Fprintf(out, "  %d  license is for a different product\n", exitWrongProduct)
+}
+
 func main() {
+	flag.Usage = usage
 	flag.Parse()
 
-	if err := checkLicense(*verbose); err != nil {
-		fmt.Fprintf(os.Stderr, "License check failed: %s\n", err)
-		os.Exit(1)
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Invalid -format %q, expected text or json\n", *format)
+		os.Exit(exitUsage)
+	}
+
+	res := result{Status: "ok", Reason: "ok", Warnings: []string{}, Problems: []problem{}}
+
+	report, source, err := checkLicense(*verbose && *format == "text")
+	res.Source = source
+	if err != nil {
+		report = &lib.ValidationReport{Problems: []*lib.ValidationError{err}}
+	}
+
+	if license := report.License; license != nil {
+		res.License = &license.Info
+		days := license.Status(time.Now(), 0).DaysLeft
+		res.DaysRemaining = &days
+		if report.OK() && days < *warnDays {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("License expires in %d days", days))
+		}
+	}
+
+	for _, p := range report.Problems {
+		res.Problems = append(res.Problems, problem{Code: p.Code, Field: p.Field, Error: p.Error()})
+	}
+	if !report.OK() {
+		res.Status = "invalid"
+		res.Reason = report.Problems[0].Code
+		res.Error = report.Problems[0].Error()
+		res.ExitCode = exitFailure
+		if code, ok := exitCodes[res.Reason]; ok {
+			res.ExitCode = code
+		}
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(res)
+		os.Exit(res.ExitCode)
+	}
+
+	for _, w := range res.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+
+	if !report.OK() {
+		for _, p := range res.Problems {
+			fmt.Fprintf(os.Stderr, "License check failed: %s\n", p.Error)
+		}
+		os.Exit(res.ExitCode)
 	}
 
 	fmt.Println("License OK")
 }
 
-func checkLicense(verbose bool) error {
-	license, err := lib.ReadLicenseFromFile(*licFile)
+// checkLicense reports every problem found with the license and the source
+// it was found in. The error is set when the inputs could not be read and
+// nothing was checked.
+func checkLicense(verbose bool) (*lib.ValidationReport, string, *lib.ValidationError) {
+	located, err := locateLicense()
 	if err != nil {
-		return fmt.Errorf("Read License failed: %s\n", err)
+		return nil, "", readError("license", fmt.Errorf("Read License failed: %w", err))
 	}
+	license := located.License
 
 	if verbose {
+		fmt.Println("Source:", located)
 		fmt.Println("Name:", license.Info.Name)
 		fmt.Println("Expiry:", license.Info.Expiration)
 		fmt.Println("Key:", license.Key)
 	}
 
-	if err := license.ValidateLicenseKey(*certKey); err != nil {
-		return err
+	keys, err := readTrustedKey(*certKey)
+	if err != nil {
+		return &lib.ValidationReport{License: license, Problems: []*lib.ValidationError{
+			readError("public_key", fmt.Errorf("Read public key failed: %w", err)),
+		}}, located.String(), nil
 	}
 
-	if verbose {
-		fmt.Println("License key verified!")
+	opts := []lib.VerifyOption{lib.WithTrustStore(keys)}
+	if *crlFile != "" {
+		opts = append(opts, lib.WithRevocationListFrom(*crlFile), lib.WithMinRevocationSequence(*crlMinSeq))
+		if *crlCert != "" {
+			crlKey, err := lib.ReadPublicKeyFromFile(*crlCert)
+			if err != nil {
+				return &lib.ValidationReport{License: license, Problems: []*lib.ValidationError{
+					readError("revocation_list", fmt.Errorf("Read revocation list key failed: %w", err)),
+				}}, located.String(), nil
+			}
+			opts = append(opts, lib.WithRevocationKey(crlKey))
+		}
+		if *crlState != "" {
+			opts = append(opts, lib.WithRevocationStateFile(*crlState))
+		}
 	}
-
-	if err := license.CheckLicenseInfo(); err != nil {
-		return err
+	if *product != "" {
+		opts = append(opts, lib.WithRequiredProduct(*product))
+	}
+	if *fingerprint != "" {
+		opts = append(opts, lib.WithFingerprint(*fingerprint))
 	}
 
-	if verbose {
+	report := lib.NewVerifier(opts...).Validate(context.Background(), license)
+
+	if verbose && report.OK() {
 		fmt.Println("License checks OK!")
 	}
 
-	return nil
+	return report, located.String(), nil
+}
+
+// locateLicense finds the license with a LicenseLocator. license.json in the
+// working directory, where lcheck used to only look, is the last fallback so
+// that it does not shadow the sources an explicit install uses.
+func locateLicense() (*lib.LocatedLicense, error) {
+	locator := &lib.LicenseLocator{Path: *licFile, Product: *product, Dir: *licDir}
+	located, err := locator.Locate()
+	if err == lib.ErrNoLicenseFound && *licFile == "" {
+		if _, statErr := os.Stat("license.json"); statErr == nil {
+			locator.Path = "license.json"
+			return locator.Locate()
+		}
+	}
+	return located, err
+}
+
+// readTrustedKey reads an RSA or Ed25519 public key
+func readTrustedKey(path string) (*lib.TrustStore, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := lib.NewTrustStore()
+	if err != nil {
+		return nil, err
+	}
+	return keys, keys.AddPEM(data)
+}
+
+func readError(field string, err error) *lib.ValidationError {
+	return &lib.ValidationError{Code: lib.CodeReadError, Field: field, Err: err}
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func runAudit(cfg *Config, args []string) error {
+	fs := newFlagSet("audit verify", "")
+	certKey := fs.String("cert", "", "Public key checkpoints are signed with. Defaults to the configured public keys of every product.")
+	allowUnsigned := fs.Bool("allow-unsigned-tail", false, "Accept entries after the last signed checkpoint, such as those of a run that is still writing")
+
+	sub, args, err := splitSubcommand("audit", fs, args)
+	if err != nil {
+		return err
+	}
+	if sub != "verify" {
+		fs.Usage()
+		return usagef("Unknown audit command: '%s'", sub)
+	}
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	if cfg.AuditLog == "" {
+		return usagef("Audit log is disabled, set audit_log in the config file")
+	}
+
+	var keys *lib.TrustStore
+	if *certKey != "" {
+		publicKey, err := lib.ReadPublicKeyFromFile(*certKey)
+		if err != nil {
+			return err
+		}
+		keys, err = lib.NewTrustStore(publicKey)
+	} else {
+		keys, err = cfg.publicKeys()
+	}
+	if err != nil {
+		return err
+	}
+
+	report, err := lib.VerifyAuditLogFile(cfg.AuditLog, keys, cfg.AuditSignEvery)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Entries:", report.Entries)
+	fmt.Println("Signed checkpoints:", report.Signed)
+	fmt.Println("Last hash:", report.LastHash)
+	if tail := report.UnsignedTail(); tail > 0 {
+		if !*allowUnsigned {
+			return fmt.Errorf("%w: %d entries after the last signed checkpoint", lib.ErrAuditUnsigned, tail)
+		}
+		fmt.Printf("Warning: %d entries after the last signed checkpoint\n", tail)
+	}
+	fmt.Println("Audit log OK")
+
+	return nil
+}
+
+// publicKeys returns the top level public key and the public keys of every
+// configured product. Key files that have not been generated are skipped.
+func (cfg *Config) publicKeys() (*lib.TrustStore, error) {
+	files := []string{cfg.PublicKey}
+	for _, name := range cfg.productNames() {
+		if prod := cfg.Products[name]; prod.PublicKey != "" {
+			files = append(files, prod.PublicKey)
+		}
+	}
+
+	keys, err := lib.NewTrustStore()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		publicKey, err := lib.ReadPublicKeyFromFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Reading public key %s failed: %s", file, err)
+		}
+		if err := keys.Add(publicKey); err != nil {
+			return nil, err
+		}
+	}
+
+	if keys.Len() == 0 {
+		return nil, fmt.Errorf("None of the configured public keys exist: %s", strings.Join(files, ", "))
+	}
+	return keys, nil
+}
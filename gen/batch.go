@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+// licenseSpec - One license to generate in a batch. Expiry uses the same
+// format as the -expiry flag and may be left out when the plan has a duration.
+type licenseSpec struct {
+	Row    int    `json:"-"`
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Expiry string `json:"expiry"`
+	Plan   string `json:"plan"`
+
+	// err is set when the row itself could not be parsed
+	err error
+}
+
+// batchResult - Outcome of a single batch row, as written to the report
+type batchResult struct {
+	Row   int    `json:"row"`
+	ID    string `json:"id,omitempty"`
+	Name  string `json:"name"`
+	File  string `json:"file,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// batchReport - Summary of a batch run
+type batchReport struct {
+	Input     string        `json:"input"`
+	Total     int           `json:"total"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Results   []batchResult `json:"results"`
+}
+
+// fileNameData is what the -out-template is executed with
+type fileNameData struct {
+	Row    int
+	ID     string
+	Name   string
+	Expiry time.Time
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+var templateFuncs = template.FuncMap{
+	// slug makes a string safe to use as a file name
+	"slug": func(s string) string {
+		return strings.Trim(unsafeFileChars.ReplaceAllString(s, "-"), "-")
+	},
+	"date": func(t time.Time) string {
+		return t.Format("2006-01-02")
+	},
+}
+
+// readSpecs reads license specs from a CSV file with a header row or from a
+// JSON lines file, chosen by the file extension
+func readSpecs(path string) ([]licenseSpec, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return readCSVSpecs(file)
+	case ".json", ".jsonl", ".ndjson":
+		return readJSONSpecs(file)
+	default:
+		return nil, fmt.Errorf("Unknown batch input format: %s", path)
+	}
+}
+
+func readCSVSpecs(r io.Reader) ([]licenseSpec, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Reading CSV header failed: %s", err)
+	}
+
+	columns := map[string]int{}
+	for i, col := range header {
+		columns[strings.ToLower(strings.TrimSpace(col))] = i
+	}
+	for _, required := range []string{"name"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV input has no '%s' column", required)
+		}
+	}
+
+	field := func(record []string, col string) string {
+		if i, ok := columns[col]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var specs []licenseSpec
+	for row := 1; ; row++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if perr, ok := err.(*csv.ParseError); ok {
+			// keep the row so that the error shows up in the report
+			specs = append(specs, licenseSpec{Row: row, err: perr})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		specs = append(specs, licenseSpec{
+			Row:    row,
+			ID:     field(record, "id"),
+			Name:   field(record, "name"),
+			Expiry: field(record, "expiry"),
+			Plan:   field(record, "plan"),
+		})
+	}
+
+	return specs, nil
+}
+
+func readJSONSpecs(r io.Reader) ([]licenseSpec, error) {
+	scanner := bufio.NewScanner(r)
+
+	var specs []licenseSpec
+	for row := 1; scanner.Scan(); row++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		spec := licenseSpec{Row: row}
+		if err := json.Unmarshal(line, &spec); err != nil {
+			// keep the row so that the error shows up in the report
+			spec.err = err
+		}
+		specs = append(specs, spec)
+	}
+
+	return specs, scanner.Err()
+}
+
+// batchJob issues the licenses of a batch with a single loaded key
+type batchJob struct {
+	cfg     *Config
+	issuer  *lib.Issuer
+	product string
+	sinks   *sinks
+	outDir  string
+	tmpl    *template.Template
+}
+
+// batchItem - A row that passed every check and is ready to be issued
+type batchItem struct {
+	row  int
+	spec lib.LicenseSpec
+	file string
+}
+
+// prepare checks a row and works out its license and file name without
+// issuing anything, so that conflicts between rows and with licenses already
+// in the registry are found up front
+func (job *batchJob) prepare(spec licenseSpec) (*batchItem, error) {
+	if spec.err != nil {
+		return nil, spec.err
+	}
+
+	if spec.Name == "" {
+		return nil, fmt.Errorf("Licensee name is empty")
+	}
+
+	var plan *Plan
+	if spec.Plan != "" {
+		p, err := job.cfg.plan(spec.Plan)
+		if err != nil {
+			return nil, err
+		}
+		if p.Product != "" && p.Product != job.product {
+			return nil, fmt.Errorf("Plan %q is for product %q, not %q", spec.Plan, p.Product, job.product)
+		}
+		plan = p
+	}
+
+	var date time.Time
+	var err error
+	if spec.Expiry == "" && plan != nil && plan.Duration != "" {
+		date, err = addPeriod(today(), plan.Duration)
+	} else {
+		date, err = time.Parse("2006-1-02", spec.Expiry)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the ID is picked here rather than by the issuer as file names may
+	// depend on it
+	id := spec.ID
+	if id == "" {
+		id = lib.NewLicenseID()
+	}
+	if err := job.sinks.checkNewID(id); err != nil {
+		return nil, err
+	}
+
+	ls := lib.LicenseSpec{LicenseInfo: lib.LicenseInfo{ID: id, Name: spec.Name, Expiration: date}}
+	if plan != nil {
+		plan.apply(&ls.LicenseInfo)
+	}
+	if err := job.cfg.checkNames(job.product, ls.Features, ls.Limits); err != nil {
+		return nil, err
+	}
+
+	file, err := job.fileName(fileNameData{Row: spec.Row, ID: id, Name: spec.Name, Expiry: date})
+	if err != nil {
+		return nil, err
+	}
+
+	return &batchItem{row: spec.Row, spec: ls, file: file}, nil
+}
+
+// fileName renders the -out-template for a row. The result must stay in the
+// output directory, whatever the row holds.
+func (job *batchJob) fileName(data fileNameData) (string, error) {
+	var name bytes.Buffer
+	if err := job.tmpl.Execute(&name, data); err != nil {
+		return "", err
+	}
+
+	clean := filepath.Clean(name.String())
+	if clean == "." {
+		return "", fmt.Errorf("File name is empty")
+	}
+	if !filepath.IsLocal(clean) {
+		return "", fmt.Errorf("File name %q is outside the output directory", name.String())
+	}
+
+	return filepath.Join(job.outDir, clean), nil
+}
+
+// rejectDuplicates fails rows whose license ID or file name is already used
+// by an earlier row, as concurrent workers would overwrite each other's files
+func rejectDuplicates(items []*batchItem, results []batchResult) {
+	ids := map[string]int{}
+	files := map[string]int{}
+	for i, item := range items {
+		if item == nil {
+			continue
+		}
+
+		if row, ok := ids[item.spec.ID]; ok {
+			results[i].Error = fmt.Sprintf("Duplicate license ID %q, also in row %d", item.spec.ID, row)
+		} else if row, ok := files[item.file]; ok {
+			results[i].Error = fmt.Sprintf("Duplicate file name %s, also in row %d", item.file, row)
+		} else {
+			ids[item.spec.ID] = item.row
+			files[item.file] = item.row
+			continue
+		}
+		items[i] = nil
+	}
+}
+
+// issue signs, saves and records the license of a prepared row
+func (job *batchJob) issue(item *batchItem) error {
+	lic, err := job.issuer.IssueLicense(context.Background(), item.spec)
+	if err != nil {
+		return err
+	}
+
+	if err := job.sinks.publish(lic); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(item.file), 0755); err != nil {
+		return err
+	}
+	if err := lic.SaveLicenseToFile(item.file); err != nil {
+		return err
+	}
+
+	return job.sinks.record(lic, lib.AuditIssue)
+}
+
+func runBatch(cfg *Config, args []string) error {
+	fs := newFlagSet("batch", "<input file>")
+	product := fs.String("product", "", "Product the licenses are for. Defaults to the configured default product.")
+	outDir := fs.String("out-dir", "", "Directory to write licenses to. Defaults to the configured output directory.")
+	outTemplate := fs.String("out-template", "{{.ID}}.json", "File name template for licenses. Fields: .Row .ID .Name .Expiry; functions: slug, date.")
+	workers := fs.Int("workers", runtime.NumCPU(), "Number of licenses to sign concurrently")
+	reportFile := fs.String("report", "", "File to write the JSON batch report to")
+	privKey := fs.String("key", "", "Private key file. Defaults to the configured private key.")
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+	batchInput := fs.Arg(0)
+
+	if *workers < 1 {
+		return usagef("-workers must be at least 1")
+	}
+
+	tmpl, err := template.New("filename").Funcs(templateFuncs).Parse(*outTemplate)
+	if err != nil {
+		return usagef("Invalid -out-template: %s", err)
+	}
+
+	pc, err := cfg.forProduct(*product)
+	if err != nil {
+		return usagef("%s", err)
+	}
+	if *outDir == "" {
+		*outDir = pc.OutputDir
+	}
+	if *privKey == "" {
+		*privKey = pc.PrivateKey
+	}
+
+	specs, err := readSpecs(batchInput)
+	if err != nil {
+		return err
+	}
+
+	pkey, err := lib.ReadPrivateKeyFromFile(*privKey)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		return err
+	}
+
+	s, err := openSinks(pc, pkey)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	issuer, err := lib.NewIssuer(pkey, lib.WithDefaultProduct(pc.product))
+	if err != nil {
+		return err
+	}
+
+	job := &batchJob{cfg: cfg, issuer: issuer, product: pc.product, sinks: s, outDir: *outDir, tmpl: tmpl}
+
+	report := batchReport{Input: batchInput, Total: len(specs), Results: make([]batchResult, len(specs))}
+	items := make([]*batchItem, len(specs))
+	for i, spec := range specs {
+		report.Results[i] = batchResult{Row: spec.Row, ID: spec.ID, Name: spec.Name}
+		if items[i], err = job.prepare(spec); err != nil {
+			report.Results[i].Error = err.Error()
+		} else {
+			report.Results[i].ID, report.Results[i].File = items[i].spec.ID, items[i].file
+		}
+	}
+	rejectDuplicates(items, report.Results)
+
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < *workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := job.issue(items[i]); err != nil {
+					report.Results[i].Error = err.Error()
+				}
+			}
+		}()
+	}
+
+	for i, item := range items {
+		if item != nil {
+			indexes <- i
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, res := range report.Results {
+		if res.Error != "" {
+			report.Failed++
+			fmt.Fprintf(os.Stderr, "Row %d (%s): %s\n", res.Row, res.Name, res.Error)
+		} else {
+			report.Succeeded++
+		}
+	}
+
+	if *reportFile != "" {
+		jsonReport, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(*reportFile, jsonReport, 0644); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Generated %d of %d licenses, %d failed\n", report.Succeeded, report.Total, report.Failed)
+	if report.Failed > 0 {
+		return fmt.Errorf("%d rows failed", report.Failed)
+	}
+
+	return nil
+}
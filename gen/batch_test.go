@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+	"github.com/dewaka/license_gen/lib/sqlitereg"
+)
+
+func testBatchJob(t *testing.T, outTemplate string) *batchJob {
+	tmpl, err := template.New("filename").Funcs(templateFuncs).Parse(outTemplate)
+	if err != nil {
+		t.Fatal("Failed to parse template:", err)
+	}
+
+	cfg := defaultConfig()
+	cfg.Products = map[string]ProductConfig{"app": {Features: []string{"sso", "reports"}}}
+	cfg.Plans = map[string]Plan{
+		"starter": {Product: "app", Duration: "1y", Features: []string{"reports"}},
+		"legacy":  {Duration: "1y", Features: []string{"fax"}},
+	}
+
+	return &batchJob{cfg: cfg, product: "app", sinks: &sinks{cfg: cfg}, outDir: "out", tmpl: tmpl}
+}
+
+func TestReadCSVSpecs(t *testing.T) {
+	input := "name,expiry,plan\n" +
+		"ACME,2030-1-01,\n" +
+		"Bad \"quote,2030-1-01,\n" +
+		"Initech,,starter\n"
+
+	specs, err := readCSVSpecs(strings.NewReader(input))
+	if err != nil {
+		t.Fatal("Expected bad rows to be reported per row, but found", err)
+	}
+	if len(specs) != 3 {
+		t.Fatalf("Expected 3 rows, but found %d", len(specs))
+	}
+
+	if specs[0].Name != "ACME" || specs[0].Expiry != "2030-1-01" || specs[0].err != nil {
+		t.Errorf("Unexpected row 1: %+v", specs[0])
+	}
+	if specs[1].Row != 2 || specs[1].err == nil {
+		t.Errorf("Expected row 2 to hold a parse error, but found %+v", specs[1])
+	}
+	if specs[2].Row != 3 || specs[2].Name != "Initech" || specs[2].Plan != "starter" {
+		t.Errorf("Unexpected row 3: %+v", specs[2])
+	}
+}
+
+func TestReadJSONSpecs(t *testing.T) {
+	input := `{"name": "ACME", "expiry": "2030-1-01"}
+not json
+
+{"name": "Initech", "plan": "starter"}
+`
+
+	specs, err := readJSONSpecs(strings.NewReader(input))
+	if err != nil {
+		t.Fatal("Failed to read JSON lines:", err)
+	}
+	if len(specs) != 3 {
+		t.Fatalf("Expected 3 rows, but found %d", len(specs))
+	}
+	if specs[1].Row != 2 || specs[1].err == nil {
+		t.Errorf("Expected row 2 to hold a parse error, but found %+v", specs[1])
+	}
+	if specs[2].Row != 4 || specs[2].Name != "Initech" {
+		t.Errorf("Unexpected row 4: %+v", specs[2])
+	}
+}
+
+func TestBatchPrepare(t *testing.T) {
+	job := testBatchJob(t, "{{.Name}}.json")
+
+	item, err := job.prepare(licenseSpec{Row: 1, Name: "ACME", Plan: "starter"})
+	if err != nil {
+		t.Fatal("Expected row to prepare, but found", err)
+	}
+	if item.spec.ID == "" || item.file != filepath.Join("out", "ACME.json") {
+		t.Errorf("Unexpected item: %+v", item)
+	}
+
+	tests := []struct {
+		spec licenseSpec
+		err  string
+	}{
+		{licenseSpec{Row: 2, Expiry: "2030-1-01"}, "name is empty"},
+		{licenseSpec{Row: 3, Name: "ACME", Expiry: "tomorrow"}, "cannot parse"},
+		{licenseSpec{Row: 4, Name: "ACME", Plan: "legacy"}, `unknown feature "fax"`},
+		{licenseSpec{Row: 5, Name: "../../x", Expiry: "2030-1-01"}, "outside the output directory"},
+		{licenseSpec{Row: 6, Name: "/etc/x", Expiry: "2030-1-01"}, "outside the output directory"},
+	}
+	for _, test := range tests {
+		_, err := job.prepare(test.spec)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Row %d: expected error containing %q, but found %v", test.spec.Row, test.err, err)
+		}
+	}
+}
+
+func TestRejectDuplicates(t *testing.T) {
+	job := testBatchJob(t, "{{slug .Name}}.json")
+
+	specs := []licenseSpec{
+		{Row: 1, ID: "a", Name: "ACME", Expiry: "2030-1-01"},
+		{Row: 2, ID: "a", Name: "Initech", Expiry: "2030-1-01"},
+		{Row: 3, ID: "b", Name: "ACME!", Expiry: "2030-1-01"},
+		{Row: 4, ID: "c", Name: "Globex", Expiry: "2030-1-01"},
+	}
+
+	items := make([]*batchItem, len(specs))
+	results := make([]batchResult, len(specs))
+	for i, spec := range specs {
+		var err error
+		if items[i], err = job.prepare(spec); err != nil {
+			t.Fatalf("Row %d: %s", spec.Row, err)
+		}
+	}
+	rejectDuplicates(items, results)
+
+	if items[0] == nil || items[3] == nil {
+		t.Error("Expected rows 1 and 4 to be issued")
+	}
+	if items[1] != nil || !strings.Contains(results[1].Error, `Duplicate license ID "a", also in row 1`) {
+		t.Errorf("Expected row 2 to be rejected as a duplicate ID, but found %q", results[1].Error)
+	}
+	if items[2] != nil || !strings.Contains(results[2].Error, "Duplicate file name") {
+		t.Errorf("Expected row 3 to be rejected as a duplicate file, but found %q", results[2].Error)
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	dir := t.TempDir()
+	cfg := defaultConfig()
+	cfg.PrivateKey = filepath.Join(dir, "key.pem")
+	cfg.PublicKey = filepath.Join(dir, "cert.pem")
+	cfg.OutputDir = filepath.Join(dir, "out")
+	cfg.Registry = filepath.Join(dir, "licenses.db")
+	cfg.AuditLog = filepath.Join(dir, "audit.log")
+	cfg.TransparencyLog = ""
+	cfg.Products = map[string]ProductConfig{"app": {Features: []string{"sso", "reports"}}}
+	cfg.Plans = map[string]Plan{"starter": {Product: "app", Duration: "1y", Features: []string{"reports"}}}
+
+	if err := lib.GenerateCertificate(cfg.PublicKey, cfg.PrivateKey, 2048); err != nil {
+		t.Fatal("Failed to generate key:", err)
+	}
+
+	// a license issued before the batch holds the ID of row 3
+	reg, err := sqlitereg.Open(cfg.Registry)
+	if err != nil {
+		t.Fatal("Failed to open registry:", err)
+	}
+	taken := lib.NewLicense("Globex", time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	taken.Info.ID = "taken"
+	il, err := lib.NewIssuedLicense(taken, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Record(context.Background(), il); err != nil {
+		t.Fatal("Failed to record license:", err)
+	}
+	reg.Close()
+
+	input := filepath.Join(dir, "licenses.csv")
+	csv := "id,name,expiry,plan\n" +
+		"acme,ACME,2030-1-01,\n" +
+		",Initech,,starter\n" +
+		"taken,Globex,2030-1-01,\n" +
+		",Umbrella,,\n"
+	if err := ioutil.WriteFile(input, []byte(csv), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reportFile := filepath.Join(dir, "report.json")
+	err = runBatch(cfg, []string{"-product", "app", "-workers", "2", "-report", reportFile, "-out-template", "{{slug .Name}}.json", input})
+	if err == nil || err.Error() != "2 rows failed" {
+		t.Error("Expected 2 rows to fail, but found", err)
+	}
+
+	data, err := ioutil.ReadFile(reportFile)
+	if err != nil {
+		t.Fatal("Failed to read report:", err)
+	}
+	var report batchReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal("Failed to parse report:", err)
+	}
+	if report.Total != 4 || report.Succeeded != 2 || report.Failed != 2 {
+		t.Errorf("Unexpected report totals: %+v", report)
+	}
+
+	errs := []string{"", "", "already recorded", "cannot parse"}
+	for i, res := range report.Results {
+		if (errs[i] == "") != (res.Error == "") || !strings.Contains(res.Error, errs[i]) {
+			t.Errorf("Row %d: expected error containing %q, but found %q", res.Row, errs[i], res.Error)
+		}
+	}
+
+	pub, err := lib.ReadPublicKeyFromFile(cfg.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"ACME.json", "Initech.json"} {
+		lic, err := lib.ReadLicenseFromFile(filepath.Join(cfg.OutputDir, name))
+		if err != nil {
+			t.Errorf("Failed to read %s: %s", name, err)
+			continue
+		}
+		if lic.Info.ID != report.Results[i].ID || lic.Info.Product != "app" {
+			t.Errorf("%s holds unexpected license %+v", name, lic.Info)
+		}
+		if err := lic.ValidateLicenseKeyWithPublicKey(pub); err != nil {
+			t.Errorf("%s does not verify: %s", name, err)
+		}
+	}
+	for _, name := range []string{"Globex.json", "Umbrella.json"} {
+		if _, err := os.Stat(filepath.Join(cfg.OutputDir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected no file for failed row %s, but found %v", name, err)
+		}
+	}
+}
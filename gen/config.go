@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFiles are looked up in the working directory when -config is
+// not given
+var defaultConfigFiles = []string{"lgen.yaml", "lgen.yml", "lgen.toml"}
+
+// ProductConfig - Per product overrides of the top level settings
+type ProductConfig struct {
+	PrivateKey     string `yaml:"private_key" toml:"private_key"`
+	PublicKey      string `yaml:"public_key" toml:"public_key"`
+	OutputDir      string `yaml:"output_dir" toml:"output_dir"`
+	RevocationList string `yaml:"revocation_list" toml:"revocation_list"`
+
+	ProductKey       string `yaml:"product_key" toml:"product_key"`
+	ProductPublicKey string `yaml:"product_public_key" toml:"product_public_key"`
+
+	// Features and Limits list the names licenses of the product may use.
+	// Plans are checked against them when set.
+	Features []string `yaml:"features" toml:"features"`
+	Limits   []string `yaml:"limits" toml:"limits"`
+}
+
+// Config - Project configuration read from lgen.yaml or lgen.toml. Every
+// field has a default so lgen also works without a config file.
+type Config struct {
+	PrivateKey      string `yaml:"private_key" toml:"private_key"`
+	PublicKey       string `yaml:"public_key" toml:"public_key"`
+	OutputDir       string `yaml:"output_dir" toml:"output_dir"`
+	Issuer          string `yaml:"issuer" toml:"issuer"`
+	Registry        string `yaml:"registry" toml:"registry"`
+	AuditLog        string `yaml:"audit_log" toml:"audit_log"`
+	AuditSignEvery  int    `yaml:"audit_sign_every" toml:"audit_sign_every"`
+	TransparencyLog string `yaml:"transparency_log" toml:"transparency_log"`
+	RevocationList  string `yaml:"revocation_list" toml:"revocation_list"`
+
+	// Ed25519 key pair for product keys (issue -format key)
+	ProductKey       string `yaml:"product_key" toml:"product_key"`
+	ProductPublicKey string `yaml:"product_public_key" toml:"product_public_key"`
+
+	DefaultProduct string                   `yaml:"default_product" toml:"default_product"`
+	Products       map[string]ProductConfig `yaml:"products" toml:"products"`
+	Plans          map[string]Plan          `yaml:"plans" toml:"plans"`
+
+	// path of the file the config was read from, if any
+	path string
+	// product selected by forProduct
+	product string
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		PrivateKey:      "key.pem",
+		PublicKey:       "cert.pem",
+		OutputDir:       ".",
+		Issuer:          defaultIssuer(),
+		Registry:        "licenses.db",
+		AuditLog:        "audit.log",
+		TransparencyLog: "transparency.log",
+		RevocationList:  "revocations.json",
+
+		ProductKey:       "product-key.pem",
+		ProductPublicKey: "product-key.pub.pem",
+	}
+}
+
+// loadConfig reads the config at path, or the first default config file found
+// when path is empty. Relative paths in the file are relative to the file.
+func loadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+
+	if path == "" {
+		for _, name := range defaultConfigFiles {
+			if _, err := os.Stat(name); err == nil {
+				path = name
+				break
+			}
+		}
+		if path == "" {
+			return cfg, nil
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("%s: unknown field %s", path, undecoded[0])
+		}
+	default:
+		return nil, fmt.Errorf("Unknown config file format: %s", path)
+	}
+
+	cfg.path = path
+	cfg.resolvePaths(filepath.Dir(path))
+
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return cfg, nil
+}
+
+func (cfg *Config) resolvePaths(dir string) {
+	resolve := func(p *string) {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+
+	for _, p := range []*string{&cfg.PrivateKey, &cfg.PublicKey, &cfg.OutputDir,
+		&cfg.Registry, &cfg.AuditLog, &cfg.TransparencyLog, &cfg.RevocationList,
+		&cfg.ProductKey, &cfg.ProductPublicKey} {
+		resolve(p)
+	}
+
+	for name, prod := range cfg.Products {
+		for _, p := range []*string{&prod.PrivateKey, &prod.PublicKey, &prod.OutputDir, &prod.RevocationList,
+			&prod.ProductKey, &prod.ProductPublicKey} {
+			resolve(p)
+		}
+		cfg.Products[name] = prod
+	}
+}
+
+func (cfg *Config) validate() error {
+	if cfg.AuditSignEvery < 0 {
+		return fmt.Errorf("audit_sign_every must not be negative")
+	}
+	if cfg.DefaultProduct != "" {
+		if _, ok := cfg.Products[cfg.DefaultProduct]; !ok {
+			return fmt.Errorf("default_product %q is not defined in products", cfg.DefaultProduct)
+		}
+	}
+	for name, plan := range cfg.Plans {
+		if err := cfg.validatePlan(name, plan); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// productNames returns the configured product names in sorted order
+func (cfg *Config) productNames() []string {
+	var names []string
+	for name := range cfg.Products {
+		names = append(names, name)
+	}
+	return sortedStrings(names)
+}
+
+func sortedStrings(s []string) []string {
+	sort.Strings(s)
+	return s
+}
+
+// forProduct returns the settings to use for the named product, with the
+// product overrides applied. An empty name selects the default product.
+func (cfg *Config) forProduct(name string) (*Config, error) {
+	if name == "" {
+		name = cfg.DefaultProduct
+	}
+
+	res := *cfg
+	res.product = name
+	if name == "" {
+		return &res, nil
+	}
+
+	prod, ok := cfg.Products[name]
+	if !ok {
+		if len(cfg.Products) == 0 {
+			// without configured products any product name is accepted
+			return &res, nil
+		}
+		return nil, fmt.Errorf("Unknown product %q, configured products are: %s",
+			name, strings.Join(cfg.productNames(), ", "))
+	}
+
+	if prod.PrivateKey != "" {
+		res.PrivateKey = prod.PrivateKey
+	}
+	if prod.PublicKey != "" {
+		res.PublicKey = prod.PublicKey
+	}
+	if prod.OutputDir != "" {
+		res.OutputDir = prod.OutputDir
+	}
+	if prod.RevocationList != "" {
+		res.RevocationList = prod.RevocationList
+	}
+	if prod.ProductKey != "" {
+		res.ProductKey = prod.ProductKey
+	}
+	if prod.ProductPublicKey != "" {
+		res.ProductPublicKey = prod.ProductPublicKey
+	}
+
+	return &res, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+// runConvert re-encodes a license in another format. JSON, YAML, TOML and
+// armor licenses share the license signature, which is kept between them. Converting from or to JWT or COSE signs the license again, and the
+// new signature is published and audited like an issued license.
+func runConvert(cfg *Config, args []string) error {
+	fs := newFlagSet("convert", "<license file>")
+	format := fs.String("format", "", "Format to convert to: "+strings.Join(licenseFormats, ", "))
+	outFile := fs.String("o", "", "Converted license file to write. Defaults to the license file name with the extension of the format.")
+	product := fs.String("product", "", "Product whose keys to use. Defaults to the product of the license.")
+	certKey := fs.String("cert", "", "Public key to verify the license with. Defaults to the configured public keys of the product.")
+	crlFile := fs.String("crl", "", "Revocation list file or URL. Defaults to the configured revocation list, if it exists.")
+	privKey := fs.String("key", "", "Private key file to sign JWT and COSE conversions with. Defaults to the configured private key.")
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+
+	if !contains(licenseFormats, *format) {
+		return usagef("Invalid -format %q, expected one of %s", *format, strings.Join(licenseFormats, ", "))
+	}
+
+	licFile := fs.Arg(0)
+	lic, inFormat, err := readLicenseFile(licFile)
+	if err != nil {
+		return fmt.Errorf("Read License failed: %w", err)
+	}
+
+	if *product == "" {
+		*product = lic.Info.Product
+	}
+	pc, err := cfg.forProduct(*product)
+	if err != nil {
+		return usagef("%s", err)
+	}
+	if *privKey == "" {
+		*privKey = pc.PrivateKey
+	}
+	if *outFile == "" {
+		*outFile = strings.TrimSuffix(licFile, filepath.Ext(licFile)) + formatExt(*format)
+	}
+
+	if err := checkLicense(pc, lic, *certKey, *crlFile); err != nil {
+		return fmt.Errorf("%s: %w", licFile, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(*outFile), 0755); err != nil {
+		return err
+	}
+
+	logf("Converting license %s from %s to %s\n", lic.Info.ID, inFormat, *format)
+	if !ownSignature(inFormat) && !ownSignature(*format) {
+		data, err := encodeLicense(lic, *format, nil, pc)
+		if err != nil {
+			return err
+		}
+
+		logf("Saving License to: %s\n", *outFile)
+		return ioutil.WriteFile(*outFile, data, 0644)
+	}
+
+	pkey, err := lib.ReadPrivateKeyFromFile(*privKey)
+	if err != nil {
+		return err
+	}
+	if err := lic.Sign(pkey); err != nil {
+		return err
+	}
+
+	s, err := openSinks(pc, pkey)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if err := s.publish(lic); err != nil {
+		return err
+	}
+
+	logf("Saving License to: %s\n", *outFile)
+	if err := saveLicense(lic, *outFile, *format, pkey, pc); err != nil {
+		return err
+	}
+
+	// the license ID is already in the registry, only the new signature is
+	// recorded
+	return s.auditLicense(lic, lib.AuditConvert)
+}
+
+// ownSignature reports whether licenses in format carry their own signature
+// in place of the license signature
+func ownSignature(format string) bool {
+	return format == lib.FormatJWT || format == lib.FormatCOSE
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+// embedTemplate is the Go source lgen embed writes. Keys are string constants
+// so the package needs nothing but lib to build.
+var embedTemplate = template.Must(template.New("embed").Parse(`// Code generated by lgen embed. DO NOT EDIT.
+
+// Package {{.Package}} holds the license policy of this application: the
+// public keys licenses must be signed with{{if .Product}} and the product they
+// must be for{{end}}.
+package {{.Package}}
+
+import "github.com/dewaka/license_gen/lib"
+
+// Policy returns the embedded license policy
+func Policy() lib.EmbeddedPolicy {
+	return lib.EmbeddedPolicy{
+		PublicKeys: []string{
+{{- range $i, $k := .Keys}}
+			publicKey{{$i}}, // {{$k.ID}}
+{{- end}}
+		},
+		Product:        {{printf "%q" .Product}},
+		GracePeriod:    {{printf "%d" .GracePeriod}}, // {{.GracePeriod}}
+		RevocationList: {{printf "%q" .RevocationList}},
+	}
+}
+
+// NewVerifier returns a Verifier enforcing the embedded policy
+func NewVerifier(opts ...lib.VerifyOption) (*lib.Verifier, error) {
+	return lib.NewEmbeddedVerifier(Policy(), opts...)
+}
+{{range $i, $k := .Keys}}
+const publicKey{{$i}} = ` + "`{{$k.PEM}}`" + `
+{{end}}`))
+
+type embedKey struct {
+	ID  string
+	PEM string
+}
+
+type embedData struct {
+	Package        string
+	Keys           []embedKey
+	Product        string
+	GracePeriod    time.Duration
+	RevocationList string
+}
+
+// runEmbed generates a Go package holding the trusted public keys and license
+// policy, so applications do not read them from files users can replace
+func runEmbed(cfg *Config, args []string) error {
+	fs := newFlagSet("embed", "")
+	var certs stringList
+	fs.Var(&certs, "cert", "Public key to trust, RSA or Ed25519 for COSE licenses. Can be repeated. Defaults to the configured public key and product public key.")
+	product := fs.String("product", "", "Product licenses must be for. Defaults to the configured default product.")
+	pkg := fs.String("package", "licensepolicy", "Package name of the generated file")
+	outFile := fs.String("o", "", "Go file to write. Defaults to policy.go in a directory named after the package.")
+	grace := fs.String("grace", "", "Grace period after expiry in which licenses are still accepted, such as 7d")
+	crl := fs.String("crl", "", "Revocation list file or URL the application checks licenses against")
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	if !token.IsIdentifier(*pkg) {
+		return usagef("Invalid -package %q", *pkg)
+	}
+
+	pc, err := cfg.forProduct(*product)
+	if err != nil {
+		return usagef("%s", err)
+	}
+	if len(certs) == 0 {
+		certs = stringList{pc.PublicKey}
+		if _, err := os.Stat(pc.ProductPublicKey); err == nil {
+			certs = append(certs, pc.ProductPublicKey)
+		}
+	}
+	if *outFile == "" {
+		*outFile = filepath.Join(*pkg, "policy.go")
+	}
+
+	data := embedData{
+		Package:        *pkg,
+		Product:        pc.product,
+		RevocationList: *crl,
+	}
+	if *grace != "" {
+		now := time.Now()
+		until, err := addPeriod(now, *grace)
+		if err != nil {
+			return usagef("Invalid -grace: %s", err)
+		}
+		data.GracePeriod = until.Sub(now).Round(time.Hour)
+	}
+
+	for _, cert := range certs {
+		pemData, err := ioutil.ReadFile(cert)
+		if err != nil {
+			return err
+		}
+		if block, _ := pem.Decode(pemData); block == nil {
+			return fmt.Errorf("%s: not a PEM encoded public key", cert)
+		}
+		keyID, err := pemKeyID(pemData)
+		if err != nil {
+			return fmt.Errorf("%s: %w", cert, err)
+		}
+		data.Keys = append(data.Keys, embedKey{ID: keyID, PEM: strings.TrimSpace(string(pemData))})
+		logf("Embedding public key %s from %s\n", keyID, cert)
+	}
+
+	var src bytes.Buffer
+	if err := embedTemplate.Execute(&src, data); err != nil {
+		return err
+	}
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		return fmt.Errorf("Generated code does not parse: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(*outFile), 0755); err != nil {
+		return err
+	}
+
+	logf("Saving license policy to: %s\n", *outFile)
+	return ioutil.WriteFile(*outFile, formatted, 0644)
+}
+
+// pemKeyID returns the key ID of a PEM encoded RSA or Ed25519 public key
+func pemKeyID(pemData []byte) (string, error) {
+	edKey, err := lib.ReadEd25519PublicKey(bytes.NewReader(pemData))
+	if err == nil {
+		return lib.Ed25519KeyID(edKey)
+	}
+	if !errors.Is(err, lib.ErrNotEd25519Key) {
+		return "", err
+	}
+
+	publicKey, err := lib.ReadPublicKey(bytes.NewReader(pemData))
+	if err != nil {
+		return "", err
+	}
+	return lib.KeyID(publicKey)
+}
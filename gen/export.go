@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"rsc.io/qr"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+// runExport writes a license in a form for installing it elsewhere. For now
+// that is a QR code to scan on kiosks and phones. The code holds the product
+// key of a product key file, as written by issue -format key, and the armored
+// license of a license file.
+func runExport(cfg *Config, args []string) error {
+	fs := newFlagSet("export", "<license or product key file>")
+	qrFile := fs.String("qr", "", "QR code file to write, a .png or .svg image")
+	product := fs.String("product", "", "Product whose product public key checks a product key file. Defaults to the configured defaults.")
+	scale := fs.Int("scale", 8, "Image pixels per QR module")
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+
+	if *qrFile == "" {
+		return usagef("-qr is required")
+	}
+	ext := strings.ToLower(filepath.Ext(*qrFile))
+	if ext != ".png" && ext != ".svg" {
+		return usagef("Invalid -qr %q, expected a .png or .svg file", *qrFile)
+	}
+	if *scale < 1 {
+		return usagef("Invalid -scale %d", *scale)
+	}
+
+	data, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("Read License failed: %w", err)
+	}
+
+	var payload string
+	if lic, err := lib.ReadLicense(bytes.NewReader(data)); err == nil {
+		if format := lib.DetectFormat(data); format == lib.FormatJWT || format == lib.FormatCOSE {
+			return usagef("%s licenses cannot be exported, convert the license to json first", format)
+		}
+		armored, err := lic.Armor()
+		if err != nil {
+			return err
+		}
+		payload = string(armored)
+	} else {
+		pc, err := cfg.forProduct(*product)
+		if err != nil {
+			return usagef("%s", err)
+		}
+		if payload, err = checkProductKey(cfg, pc, string(data)); err != nil {
+			return fmt.Errorf("Read License failed: %w", err)
+		}
+	}
+
+	code, err := qr.Encode(payload, qr.L)
+	if err != nil {
+		return fmt.Errorf("Encoding %d bytes as a QR code failed: %w", len(payload), err)
+	}
+	code.Scale = *scale
+
+	image := code.PNG()
+	if ext == ".svg" {
+		image = qrSVG(code)
+	}
+
+	logf("QR code of %d modules holding %d bytes saved to: %s\n", code.Size, len(payload), *qrFile)
+	return ioutil.WriteFile(*qrFile, image, 0644)
+}
+
+// checkProductKey verifies a product key with the configured product public
+// key and returns it in upper case groups, which QR codes hold in their
+// alphanumeric mode
+func checkProductKey(cfg *Config, pc *Config, productKey string) (string, error) {
+	key, err := lib.ReadEd25519PublicKeyFromFile(pc.ProductPublicKey)
+	if err != nil {
+		return "", fmt.Errorf("Reading product public key %s failed: %w", pc.ProductPublicKey, err)
+	}
+
+	productKey = strings.ToUpper(strings.TrimSpace(productKey))
+	if _, err := lib.DecodeProductKey(productKey, cfg.Products[pc.product].Features, key); err != nil {
+		return "", err
+	}
+	return productKey, nil
+}
+
+// qrQuietZone is the white border around a QR code, in modules
+const qrQuietZone = 4
+
+// qrSVG renders a QR code as an SVG image with one path for all dark modules
+func qrSVG(code *qr.Code) []byte {
+	size := code.Size + 2*qrQuietZone
+
+	var path strings.Builder
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if !code.Black(x, y) {
+				continue
+			}
+			// merge runs of dark modules on a row into one rectangle
+			run := 1
+			for code.Black(x+run, y) {
+				run++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", x+qrQuietZone, y+qrQuietZone, run, run)
+			x += run - 1
+		}
+	}
+
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">
+<rect width="100%%" height="100%%" fill="#fff"/>
+<path fill="#000" d="%s"/>
+</svg>
+`, size*code.Scale, size*code.Scale, size, size, path.String()))
+}
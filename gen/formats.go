@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+// licenseFormats are the values of -format for license files
+var licenseFormats = []string{lib.FormatJSON, lib.FormatYAML, lib.FormatTOML, lib.FormatArmor, lib.FormatJWT, lib.FormatCOSE}
+
+// readLicenseFile reads a license in any format ReadLicense supports and
+// returns the format it was in
+func readLicenseFile(path string) (*lib.LicenseData, string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	lic, err := lib.ReadLicense(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return lic, lib.DetectFormat(data), nil
+}
+
+// encodeLicense encodes a signed license in the given format. JWT and COSE
+// licenses carry their own signature, made with pkey for a JWT and with the
+// product key of pc for COSE; the other formats keep the license signature.
+func encodeLicense(lic *lib.LicenseData, format string, pkey *rsa.PrivateKey, pc *Config) ([]byte, error) {
+	switch format {
+	case lib.FormatJWT:
+		token, err := lic.JWT(pkey)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(token + "\n"), nil
+	case lib.FormatCOSE:
+		key, err := lib.ReadEd25519PrivateKeyFromFile(pc.ProductKey)
+		if err != nil {
+			return nil, fmt.Errorf("Reading product key %s failed: %w", pc.ProductKey, err)
+		}
+		return lic.COSE(key)
+	}
+
+	codec, err := lib.CodecFor(format)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Encode(lic)
+}
+
+// saveLicense writes lic to path in the given format
+func saveLicense(lic *lib.LicenseData, path, format string, pkey *rsa.PrivateKey, pc *Config) error {
+	data, err := encodeLicense(lic, format, pkey, pc)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// verifySignature checks the signature of a license read in format with the
+// public key in certKey, an Ed25519 key for COSE licenses
+func verifySignature(lic *lib.LicenseData, format, certKey string) error {
+	if format == lib.FormatCOSE {
+		pub, err := lib.ReadEd25519PublicKeyFromFile(certKey)
+		if err != nil {
+			return err
+		}
+		return lic.ValidateCOSE(pub)
+	}
+
+	publicKey, err := lib.ReadPublicKeyFromFile(certKey)
+	if err != nil {
+		return err
+	}
+	return lic.ValidateLicenseKeyWithPublicKey(publicKey)
+}
+
+// formatExt returns the file extension lgen uses for a license format
+func formatExt(format string) string {
+	switch format {
+	case lib.FormatArmor:
+		return ".txt"
+	case lib.FormatJSON:
+		return ".json"
+	}
+	return "." + format
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+// inspection - Everything lgen inspect reports about a license. This is the
+// schema of 'lgen inspect -o json'.
+type inspection struct {
+	File        string            `json:"file"`
+	Format      string            `json:"format"`
+	Algorithm   string            `json:"algorithm"`
+	KeyID       string            `json:"key_id,omitempty"`
+	Fingerprint string            `json:"fingerprint,omitempty"`
+	Info        lib.LicenseInfo   `json:"info"`
+	Status      lib.LicenseStatus `json:"status"`
+	Signature   string            `json:"signature"`
+	Error       string            `json:"error,omitempty"`
+	Inclusion   string            `json:"inclusion,omitempty"`
+}
+
+// Signature verification results
+const (
+	sigValid      = "valid"
+	sigInvalid    = "invalid"
+	sigNotChecked = "not checked"
+)
+
+func runInspect(cfg *Config, args []string) error {
+	fs := newFlagSet("inspect", "<license file>")
+	certKey := fs.String("cert", "", "Public key to verify the signature with, the Ed25519 product public key for COSE licenses. The signature is not checked when empty.")
+	output := fs.String("o", "text", "Output format: text or json")
+	grace := fs.String("grace", "", "Grace period after expiry to compute the status with, such as 7d")
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+
+	if *output != "text" && *output != "json" {
+		return usagef("Invalid -o %q, expected text or json", *output)
+	}
+
+	lic, format, err := readLicenseFile(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("Read License failed: %w", err)
+	}
+
+	var gracePeriod time.Duration
+	if *grace != "" {
+		until, err := addPeriod(lic.Info.Expiration, *grace)
+		if err != nil {
+			return usagef("Invalid -grace: %s", err)
+		}
+		gracePeriod = until.Sub(lic.Info.Expiration)
+	}
+
+	ins := inspection{
+		File:      fs.Arg(0),
+		Format:    format,
+		Algorithm: lic.Algorithm(),
+		KeyID:     lic.KeyID,
+		Info:      lic.Info,
+		Status:    lic.Status(time.Now(), gracePeriod),
+		Signature: sigNotChecked,
+	}
+
+	if *certKey != "" && format == lib.FormatCOSE {
+		ins.Signature = sigValid
+		if err := verifySignature(lic, format, *certKey); err != nil {
+			ins.Signature = sigInvalid
+			ins.Error = err.Error()
+		}
+	} else if *certKey != "" {
+		publicKey, err := lib.ReadPublicKeyFromFile(*certKey)
+		if err != nil {
+			return err
+		}
+
+		if ins.Fingerprint, err = lib.Fingerprint(publicKey); err != nil {
+			return err
+		}
+
+		ins.Signature = sigValid
+		if err := lic.ValidateLicenseKeyWithPublicKey(publicKey); err != nil {
+			ins.Signature = sigInvalid
+			ins.Error = err.Error()
+		}
+
+		if lic.Proof != nil {
+			ins.Inclusion = sigValid
+			if err := lic.VerifyInclusion(publicKey); err != nil {
+				ins.Inclusion = sigInvalid
+			}
+		}
+	}
+
+	if *output == "json" {
+		return printJSON(ins)
+	}
+
+	printInspection(&ins)
+	return nil
+}
+
+func printInspection(ins *inspection) {
+	row := func(label string, value interface{}) {
+		fmt.Printf("%-14s %v\n", label+":", value)
+	}
+
+	row("File", ins.File)
+	row("Format", ins.Format)
+	row("License ID", ins.Info.ID)
+	if ins.Info.Product != "" {
+		row("Product", ins.Info.Product)
+	}
+	row("Licensee", ins.Info.Name)
+	if ins.Info.Issuer != "" {
+		row("Issuer", ins.Info.Issuer)
+	}
+	if ins.Info.NotBefore != nil {
+		row("Not before", ins.Info.NotBefore.Format(time.RFC3339))
+	}
+	row("Expiry", ins.Info.Expiration.Format(time.RFC3339))
+	if ins.Info.Predecessor != "" {
+		row("Renews", ins.Info.Predecessor)
+	}
+	if len(ins.Info.Features) > 0 {
+		row("Features", strings.Join(ins.Info.Features, ", "))
+	}
+	for _, k := range sortedLimitNames(ins.Info.Limits) {
+		row("Limit "+k, ins.Info.Limits[k])
+	}
+	var keys []string
+	for k := range ins.Info.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		row("Meta "+k, ins.Info.Metadata[k])
+	}
+
+	row("Algorithm", ins.Algorithm)
+	row("Key ID", ins.KeyID)
+	if ins.Fingerprint != "" {
+		row("Fingerprint", ins.Fingerprint)
+	}
+
+	status := ins.Status.Status
+	switch ins.Status.Status {
+	case lib.StatusValid:
+		status = fmt.Sprintf("%s, %d days left", status, ins.Status.DaysLeft)
+	case lib.StatusNotYetValid:
+		status = fmt.Sprintf("%s before %s", status, ins.Status.NotBefore.Format(time.RFC3339))
+	case lib.StatusGrace:
+		status = fmt.Sprintf("%s until %s", status, ins.Status.GraceUntil.Format(time.RFC3339))
+	case lib.StatusExpired:
+		status = fmt.Sprintf("%s %d days ago", status, -ins.Status.DaysLeft)
+	}
+	row("Status", status)
+
+	signature := ins.Signature
+	if ins.Error != "" {
+		signature = fmt.Sprintf("%s (%s)", signature, ins.Error)
+	}
+	row("Signature", signature)
+	if ins.Inclusion != "" {
+		row("Inclusion", ins.Inclusion)
+	}
+}
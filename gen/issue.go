@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func runIssue(cfg *Config, args []string) error {
+	fs := newFlagSet("issue", "")
+	name := fs.String("name", "", "Name of the Licensee")
+	expDate := fs.String("expiry", "", "Expiry date for the License. Expected format is 2006-1-02. Defaults to the plan duration from today.")
+	notBefore := fs.String("not-before", "", "Date the license becomes valid. Expected format is 2006-1-02. Valid immediately when empty.")
+	planName := fs.String("plan", "", "Plan from the config file to pre-fill the license from")
+	product := fs.String("product", "", "Product the license is for. Defaults to the plan product or the configured default product.")
+	var features stringList
+	fs.Var(&features, "feature", "Feature to grant in addition to the plan features. Can be repeated.")
+	limits := keyValues{}
+	fs.Var(limits, "limit", "Limit as name=number, overriding the plan limit. Can be repeated.")
+	metadata := keyValues{}
+	fs.Var(metadata, "meta", "Metadata as key=value, overriding the plan metadata. Can be repeated.")
+	id := fs.String("id", "", "License ID. A random ID is generated when empty.")
+	format := fs.String("format", "json", "License format: "+strings.Join(licenseFormats, ", ")+" for a license file, key for a product key")
+	licFile := fs.String("o", "", "License file to write. Defaults to license with the extension of the format, such as license.json or license.txt for armor, in the configured output directory.")
+	force := fs.Bool("force", false, "Overwrite the license file if it exists")
+	privKey := fs.String("key", "", "Private key file. Defaults to the configured private key.")
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	if *name == "" {
+		return usagef("-name is required")
+	}
+	if *format != "key" && !contains(licenseFormats, *format) {
+		return usagef("Invalid -format %q, expected key or one of %s", *format, strings.Join(licenseFormats, ", "))
+	}
+
+	var plan *Plan
+	if *planName != "" {
+		p, err := cfg.plan(*planName)
+		if err != nil {
+			return usagef("%s", err)
+		}
+		plan = p
+	}
+
+	var date time.Time
+	var err error
+	if *expDate == "" && plan != nil && plan.Duration != "" {
+		if date, err = addPeriod(today(), plan.Duration); err != nil {
+			return err
+		}
+	} else if date, err = parseDate("expiry", *expDate); err != nil {
+		return err
+	}
+
+	var start time.Time
+	if *notBefore != "" {
+		if start, err = parseDate("not-before", *notBefore); err != nil {
+			return err
+		}
+		if !start.Before(date) {
+			return usagef("-not-before must be before the expiry date")
+		}
+	}
+
+	if *product == "" && plan != nil {
+		*product = plan.Product
+	}
+	pc, err := cfg.forProduct(*product)
+	if err != nil {
+		return usagef("%s", err)
+	}
+	if *privKey == "" {
+		*privKey = pc.PrivateKey
+	}
+	if *licFile == "" {
+		*licFile = filepath.Join(pc.OutputDir, "license"+formatExt(*format))
+	}
+	if err := checkOverwrite(*force, *licFile); err != nil {
+		return err
+	}
+
+	lic := lib.NewLicense(*name, date)
+	lic.Info.Product = pc.product
+	if *format == "key" {
+		lic.Info.ID = lib.NewProductKeyID()
+	}
+	if *id != "" {
+		lic.Info.ID = *id
+	}
+	if !start.IsZero() {
+		lic.Info.NotBefore = &start
+	}
+
+	if plan != nil {
+		plan.apply(&lic.Info)
+	}
+	if err := applyOverrides(&lic.Info, features, limits, metadata); err != nil {
+		return err
+	}
+	if err := cfg.checkNames(pc.product, lic.Info.Features, lic.Info.Limits); err != nil {
+		return usagef("%s", err)
+	}
+
+	var productKey string
+	if *format == "key" {
+		if productKey, err = encodeProductKey(cfg, pc, lic); err != nil {
+			return err
+		}
+	}
+
+	if date.Before(time.Now()) {
+		fmt.Fprintf(os.Stderr, "Warning: expiry date %s is in the past\n", date.Format("2006-01-02"))
+	}
+
+	logf("License ID: %s\n", lic.Info.ID)
+	if lic.Info.Product != "" {
+		logf("Product: %s\n", lic.Info.Product)
+	}
+	logf("Licensee: %s\n", *name)
+	if lic.Info.NotBefore != nil {
+		logf("Not before: %s\n", start)
+	}
+	logf("Expiry date: %s\n", date)
+	if len(lic.Info.Features) > 0 {
+		logf("Features: %s\n", strings.Join(lic.Info.Features, ", "))
+	}
+	for _, k := range sortedLimitNames(lic.Info.Limits) {
+		logf("Limit %s: %d\n", k, lic.Info.Limits[k])
+	}
+	logf("Signing with private key: %s\n", *privKey)
+
+	pkey, err := lib.ReadPrivateKeyFromFile(*privKey)
+	if err != nil {
+		return err
+	}
+
+	if err := lic.Sign(pkey); err != nil {
+		return err
+	}
+
+	s, err := openSinks(pc, pkey)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if err := s.checkNewID(lic.Info.ID); err != nil {
+		return err
+	}
+	if err := s.publish(lic); err != nil {
+		return err
+	}
+	if lic.Proof != nil {
+		logf("Published to transparency log %s at index %d\n", pc.TransparencyLog, lic.Proof.LeafIndex)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(*licFile), 0755); err != nil {
+		return err
+	}
+
+	if *format == "key" {
+		logf("Signing OK. Saving product key to: %s\n", *licFile)
+		fmt.Println(productKey)
+		if err := ioutil.WriteFile(*licFile, []byte(productKey+"\n"), 0644); err != nil {
+			return err
+		}
+		return s.record(lic, lib.AuditIssue)
+	}
+
+	data, err := encodeLicense(lic, *format, pkey, pc)
+	if err != nil {
+		return err
+	}
+
+	if *verbose {
+		fmt.Println("Signing OK. Saving License to:", *licFile)
+		if *format == lib.FormatJSON {
+			fmt.Println("*** BEGIN LICENSE ***")
+			lic.WriteLicense(os.Stdout)
+			fmt.Println("\n*** END LICENSE ***")
+		} else if *format != lib.FormatCOSE {
+			os.Stdout.Write(data)
+		}
+	}
+
+	if err := ioutil.WriteFile(*licFile, data, 0644); err != nil {
+		return err
+	}
+
+	return s.record(lic, lib.AuditIssue)
+}
+
+// applyOverrides adds the features, limits and metadata given on the command
+// line to the license
+func applyOverrides(info *lib.LicenseInfo, features []string, limits, metadata keyValues) error {
+	for _, f := range features {
+		if !info.HasFeature(f) {
+			info.Features = append(info.Features, f)
+		}
+	}
+
+	for k, v := range limits {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return usagef("Invalid -limit %s=%s, expected a number", k, v)
+		}
+		if info.Limits == nil {
+			info.Limits = make(map[string]int)
+		}
+		info.Limits[k] = n
+	}
+
+	for k, v := range metadata {
+		if info.Metadata == nil {
+			info.Metadata = make(map[string]string)
+		}
+		info.Metadata[k] = v
+	}
+
+	return nil
+}
+
+func sortedLimitNames(limits map[string]int) []string {
+	var names []string
+	for k := range limits {
+		names = append(names, k)
+	}
+	return sortedStrings(names)
+}
+
+// encodeProductKey encodes lic as a product key signed with the configured
+// Ed25519 key. Feature bits index the features configured for the product.
+func encodeProductKey(cfg *Config, pc *Config, lic *lib.LicenseData) (string, error) {
+	info := &lic.Info
+	if len(info.Limits) > 0 || len(info.Metadata) > 0 || info.NotBefore != nil {
+		return "", usagef("Limits, metadata and -not-before cannot be encoded in a product key")
+	}
+
+	features := cfg.Products[pc.product].Features
+	for _, f := range info.Features {
+		if !contains(features, f) {
+			return "", usagef("Feature %q must be listed in the product features to be encoded in a product key", f)
+		}
+	}
+
+	key, err := lib.ReadEd25519PrivateKeyFromFile(pc.ProductKey)
+	if err != nil {
+		return "", fmt.Errorf("Reading product key %s failed: %w", pc.ProductKey, err)
+	}
+
+	return lib.EncodeProductKey(info, features, key)
+}
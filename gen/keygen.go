@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func runKeygen(cfg *Config, args []string) error {
+	fs := newFlagSet("keygen", "")
+	product := fs.String("product", "", "Product whose configured key files to use")
+	certKey := fs.String("cert", "", "Public key file to write. Defaults to the configured public key.")
+	privKey := fs.String("key", "", "Private key file to write. Defaults to the configured private key.")
+	keyType := fs.String("type", "rsa", "Key type: rsa for license files or ed25519 for product keys")
+	rsaBits := fs.Int("rsa-bits", 2048, "Size of RSA key to generate")
+	force := fs.Bool("force", false, "Overwrite existing key files")
+	check := fs.Bool("check", false, "Only check that the existing key pair can be read and belongs together")
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	pc, err := cfg.forProduct(*product)
+	if err != nil {
+		return usagef("%s", err)
+	}
+	if *keyType != "rsa" && *keyType != "ed25519" {
+		return usagef("Invalid -type %q, expected rsa or ed25519", *keyType)
+	}
+	if *keyType == "ed25519" {
+		if *certKey == "" {
+			*certKey = pc.ProductPublicKey
+		}
+		if *privKey == "" {
+			*privKey = pc.ProductKey
+		}
+		if *check {
+			return usagef("-check only supports rsa keys")
+		}
+		return keygenProductKey(pc, *certKey, *privKey, *force)
+	}
+
+	if *certKey == "" {
+		*certKey = pc.PublicKey
+	}
+	if *privKey == "" {
+		*privKey = pc.PrivateKey
+	}
+
+	if *check {
+		return checkKeys(*certKey, *privKey)
+	}
+
+	if *rsaBits < 2048 {
+		return usagef("-rsa-bits must be at least 2048")
+	}
+
+	if err := checkOverwrite(*force, *certKey, *privKey); err != nil {
+		return err
+	}
+
+	logf("Generating RSA key pair: %s, %s\n", *certKey, *privKey)
+	if err := lib.GenerateCertificate(*certKey, *privKey, *rsaBits); err != nil {
+		return err
+	}
+
+	pkey, err := lib.ReadPrivateKeyFromFile(*privKey)
+	if err != nil {
+		return err
+	}
+
+	keyID, err := lib.KeyID(&pkey.PublicKey)
+	if err != nil {
+		return err
+	}
+	logf("Key ID: %s\n", keyID)
+
+	s, err := openSinks(pc, pkey)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	_, err = s.audit(lib.AuditEntry{
+		Action:  lib.AuditKeyGen,
+		KeyID:   keyID,
+		Details: map[string]string{"rsa_bits": fmt.Sprint(*rsaBits)},
+	})
+	return err
+}
+
+func checkKeys(certKey, privKey string) error {
+	publicKey, err := lib.ReadPublicKeyFromFile(certKey)
+	if err != nil {
+		return fmt.Errorf("Reading public key %s failed: %s", certKey, err)
+	}
+
+	pkey, err := lib.ReadPrivateKeyFromFile(privKey)
+	if err != nil {
+		return fmt.Errorf("Reading private key %s failed: %s", privKey, err)
+	}
+
+	if publicKey.N.Cmp(pkey.PublicKey.N) != 0 || publicKey.E != pkey.PublicKey.E {
+		return fmt.Errorf("%s is not the public key of %s", certKey, privKey)
+	}
+
+	logf("Key pair OK\n")
+	return nil
+}
+
+func checkOverwrite(force bool, files ...string) error {
+	if force {
+		return nil
+	}
+	for _, file := range files {
+		if _, err := os.Stat(file); err == nil {
+			return fmt.Errorf("%s already exists, use -force to overwrite it", file)
+		}
+	}
+	return nil
+}
+
+// keygenProductKey generates the Ed25519 key pair product keys are signed with
+func keygenProductKey(pc *Config, certKey, privKey string, force bool) error {
+	if err := checkOverwrite(force, certKey, privKey); err != nil {
+		return err
+	}
+
+	logf("Generating Ed25519 product key pair: %s, %s\n", certKey, privKey)
+	if err := lib.GenerateProductKeyPair(certKey, privKey); err != nil {
+		return err
+	}
+
+	// sign the audit checkpoint with the license key when there is one, so
+	// that the entry does not stretch the unsigned run of the audit log
+	pkey, _ := lib.ReadPrivateKeyFromFile(pc.PrivateKey)
+	s, err := openSinks(pc, pkey)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	_, err = s.audit(lib.AuditEntry{
+		Action:  lib.AuditKeyGen,
+		Details: map[string]string{"type": "ed25519", "public_key": certKey},
+	})
+	return err
+}
@@ -1,100 +1,224 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 	"time"
+)
 
-	"github.com/dewaka/license_gen/lib"
+// Exit codes
+const (
+	exitOK      = 0
+	exitFailure = 1 // the operation failed
+	exitUsage   = 2 // invalid command line or config file
 )
 
 var (
-	typePtr = flag.String("type", "", "Operation type. Valid values are license or certificate.")
-	licFile = flag.String("lic", "license.json", "License file name. Required for license generation.")
-	certKey = flag.String("cert", "cert.pem", "Public certificate key.")
-	privKey = flag.String("key", "key.pem", "Certificate key file. Required for license generation.")
-	rsaBits = flag.Int("rsa-bits", 2048, "Size of RSA key to generate. Only used when type is certificate.")
+	configFile = flag.String("config", "", "Config file. Defaults to lgen.yaml, lgen.yml or lgen.toml in the working directory.")
+	issuerName = flag.String("issuer", "", "Issuer recorded in the registry and audit log. Overrides the config file.")
+	verbose    = flag.Bool("verbose", true, "Print verbose messages")
+)
 
-	// Required info for license generation
-	name    = flag.String("name", "", "Name of the Licensee")
-	expDate = flag.String("expiry", "", "Expiry date for the License. Expected format is 2006-1-02")
+// command - A lgen subcommand
+type command struct {
+	name     string
+	synopsis string
+	run      func(cfg *Config, args []string) error
+}
 
-	verbose = flag.Bool("verbose", true, "Print verbose messages")
-)
+func commandList() []*command {
+	return []*command{
+		{"keygen", "Generate a signing key pair", runKeygen},
+		{"issue", "Issue a license", runIssue},
+		{"renew", "Renew a license with a new expiry, features or limits", runRenew},
+		{"batch", "Issue licenses from a CSV or JSON lines file", runBatch},
+		{"verify", "Verify a license file", runVerify},
+		{"inspect", "Show the contents and status of a license", runInspect},
+		{"convert", "Convert a license to another format", runConvert},
+		{"export", "Export a license as a QR code", runExport},
+		{"embed", "Generate a Go package embedding the trusted keys and policy", runEmbed},
+		{"revoke", "Revoke a license or signing key", runRevoke},
+		{"list", "List issued licenses", runList},
+		{"search", "Search issued licenses", runSearch},
+		{"show", "Show an issued license", runShow},
+		{"audit", "Verify the audit log", runAudit},
+		{"tlog", "Print transparency log tree heads and proofs", runTlog},
+	}
+}
 
-func main() {
-	flag.Parse()
+// usageError is returned for invalid command lines and exits with exitUsage
+type usageError struct {
+	msg string
+}
 
-	switch *typePtr {
-	case "lic", "license":
-		fmt.Println("Generating license")
-		if err := generateLicense(); err != nil {
-			fmt.Println("Error generating license:", err)
-		}
-	case "cert", "certificate":
-		if err := generateCertificate(); err != nil {
-			fmt.Fprintf(os.Stderr, "Certificate generation failed: %s\n", err)
-			os.Exit(1)
-		}
-	case "test":
-		hasError := false
-		if _, err := lib.ReadPublicKeyFromFile("cert.pem"); err != nil {
-			fmt.Println("Error reading public key:", err)
-			hasError = true
-		}
+func (e *usageError) Error() string {
+	return e.msg
+}
 
-		if _, err := lib.ReadPrivateKeyFromFile("key.pem"); err != nil {
-			fmt.Println("Error reading private key:", err)
-			hasError = true
+func usagef(format string, args ...interface{}) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: lgen [flags] <command> [command flags]\n\nCommands:\n")
+	for _, cmd := range commandList() {
+		fmt.Fprintf(out, "  %-8s %s\n", cmd.name, cmd.synopsis)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(out, "\nRun 'lgen <command> -h' for command flags.\n")
+	fmt.Fprintf(out, "\nExit codes: %d success, %d failure, %d invalid usage.\n", exitOK, exitFailure, exitUsage)
+}
+
+// newFlagSet returns a flag set for a command which reports errors instead of
+// exiting
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: lgen %s [flags]", name)
+		if args != "" {
+			fmt.Fprintf(fs.Output(), " %s", args)
 		}
+		fmt.Fprintf(fs.Output(), "\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
 
-		if hasError {
-			os.Exit(1)
+// parseFlags parses the command flags and checks the number of positional
+// arguments left
+func parseFlags(fs *flag.FlagSet, args []string, nargs int) error {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
 		}
-	default:
-		if *typePtr == "" {
-			fmt.Fprintf(os.Stderr, "Operation type required\n")
-		} else {
-			fmt.Fprintf(os.Stderr, "Invalid operation type: '%s'\n", *typePtr)
+		return &usageError{msg: err.Error()}
+	}
+
+	if fs.NArg() != nargs {
+		fs.Usage()
+		if nargs == 0 {
+			return usagef("Unexpected arguments: %s", strings.Join(fs.Args(), " "))
 		}
-		os.Exit(1)
+		return usagef("Expected %d arguments, found %d", nargs, fs.NArg())
 	}
+
+	return nil
 }
 
-func generateCertificate() error {
-	fmt.Println("Generating x509 Certificate")
-	return lib.GenerateCertificate("cert.pem", "key.pem", *rsaBits)
+// splitSubcommand separates the subcommand of commands like 'audit verify'
+// from the flags that follow it
+func splitSubcommand(cmd string, fs *flag.FlagSet, args []string) (string, []string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fs.Usage()
+		return "", nil, usagef("Missing %s command", cmd)
+	}
+	return args[0], args[1:], nil
 }
 
-func generateLicense() error {
-	if len(*name) == 0 {
-		return fmt.Errorf("Licensee name is empty")
+// parseDate parses a date given on the command line
+func parseDate(flagName, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, usagef("-%s is required", flagName)
 	}
 
-	date, err := time.Parse("2006-1-02", *expDate)
+	date, err := time.Parse("2006-1-02", value)
 	if err != nil {
-		return err
+		return time.Time{}, usagef("Invalid -%s %q, expected format is 2006-1-02", flagName, value)
 	}
 
-	lic := lib.NewLicense(*name, date)
+	return date, nil
+}
 
-	if *verbose {
-		fmt.Println("Licensee:", *name)
-		fmt.Println("Expiry date:", date)
-		fmt.Println("Signing with private key:", *privKey)
+// stringList is a flag that can be given multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// keyValues is a flag of key=value pairs that can be given multiple times
+type keyValues map[string]string
+
+func (kv keyValues) String() string {
+	var pairs []string
+	for k, v := range kv {
+		pairs = append(pairs, k+"="+v)
 	}
+	return strings.Join(sortedStrings(pairs), ",")
+}
 
-	if err := lic.SignWithKey(*privKey); err != nil {
-		return err
+func (kv keyValues) Set(v string) error {
+	i := strings.Index(v, "=")
+	if i <= 0 {
+		return fmt.Errorf("expected key=value, found %q", v)
 	}
+	kv[v[:i]] = v[i+1:]
+	return nil
+}
 
+func logf(format string, args ...interface{}) {
 	if *verbose {
-		fmt.Println("Signing OK. Saving License to:", *licFile)
-		fmt.Println("*** BEGIN LICENSE ***")
-		lic.WriteLicense(os.Stdout)
-		fmt.Println("\n*** END LICENSE ***")
+		fmt.Printf(format, args...)
+	}
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(exitUsage)
 	}
 
-	return lic.SaveLicenseToFile(*licFile)
+	name, args := flag.Arg(0), flag.Args()[1:]
+
+	var cmd *command
+	for _, c := range commandList() {
+		if c.name == name {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command: '%s'\n\n", name)
+		usage()
+		os.Exit(exitUsage)
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config: %s\n", err)
+		os.Exit(exitUsage)
+	}
+	if *issuerName != "" {
+		cfg.Issuer = *issuerName
+	}
+
+	os.Exit(exitCode(cmd.run(cfg, args), name))
+}
+
+func exitCode(err error, name string) int {
+	var uerr *usageError
+	switch {
+	case err == nil:
+		return exitOK
+	case err == flag.ErrHelp:
+		return exitOK
+	case errors.As(err, &uerr):
+		fmt.Fprintf(os.Stderr, "lgen %s: %s\n", name, err)
+		return exitUsage
+	default:
+		fmt.Fprintf(os.Stderr, "lgen %s failed: %s\n", name, err)
+		return exitFailure
+	}
 }
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+// Plan - A named license template from the config file. Issuing a license
+// with a plan pre-fills these fields; command line flags override them.
+type Plan struct {
+	Product  string            `yaml:"product" toml:"product"`
+	Duration string            `yaml:"duration" toml:"duration"`
+	Features []string          `yaml:"features" toml:"features"`
+	Limits   map[string]int    `yaml:"limits" toml:"limits"`
+	Metadata map[string]string `yaml:"metadata" toml:"metadata"`
+}
+
+var periodPattern = regexp.MustCompile(`^(\d+)([dwmy])$`)
+
+// parsePeriod parses a license duration such as 90d, 2w, 6m or 1y
+func parsePeriod(period string) (years, months, days int, err error) {
+	m := periodPattern.FindStringSubmatch(strings.TrimSpace(period))
+	if m == nil {
+		return 0, 0, 0, fmt.Errorf("Invalid duration %q, expected a number followed by d, w, m or y", period)
+	}
+
+	n, err := strconv.Atoi(m[1])
+	if err != nil || n == 0 {
+		return 0, 0, 0, fmt.Errorf("Invalid duration %q", period)
+	}
+
+	switch m[2] {
+	case "d":
+		return 0, 0, n, nil
+	case "w":
+		return 0, 0, 7 * n, nil
+	case "m":
+		return 0, n, 0, nil
+	default:
+		return n, 0, 0, nil
+	}
+}
+
+// addPeriod returns t moved forward by the given duration
+func addPeriod(t time.Time, period string) (time.Time, error) {
+	years, months, days, err := parsePeriod(period)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.AddDate(years, months, days), nil
+}
+
+// today returns the current date at midnight UTC, which is what durations
+// are counted from
+func today() time.Time {
+	y, m, d := time.Now().UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// validatePlan checks that a plan only references known products, features
+// and limits
+func (cfg *Config) validatePlan(name string, plan Plan) error {
+	if plan.Duration != "" {
+		if _, _, _, err := parsePeriod(plan.Duration); err != nil {
+			return fmt.Errorf("plan %q: %s", name, err)
+		}
+	}
+
+	if plan.Product != "" && len(cfg.Products) > 0 {
+		if _, ok := cfg.Products[plan.Product]; !ok {
+			return fmt.Errorf("plan %q: unknown product %q", name, plan.Product)
+		}
+	}
+
+	if err := cfg.checkNames(plan.Product, plan.Features, plan.Limits); err != nil {
+		return fmt.Errorf("plan %q: %s", name, err)
+	}
+
+	return nil
+}
+
+// checkNames checks features and limits against the names configured for
+// the product, if any
+func (cfg *Config) checkNames(product string, features []string, limits map[string]int) error {
+	prod, ok := cfg.Products[product]
+	if !ok {
+		return nil
+	}
+
+	if len(prod.Features) > 0 {
+		for _, f := range features {
+			if !contains(prod.Features, f) {
+				return fmt.Errorf("unknown feature %q for product %q", f, product)
+			}
+		}
+	}
+
+	if len(prod.Limits) > 0 {
+		for l := range limits {
+			if !contains(prod.Limits, l) {
+				return fmt.Errorf("unknown limit %q for product %q", l, product)
+			}
+		}
+	}
+
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// plan returns the named plan or an error listing the configured plans
+func (cfg *Config) plan(name string) (*Plan, error) {
+	plan, ok := cfg.Plans[name]
+	if !ok {
+		var names []string
+		for n := range cfg.Plans {
+			names = append(names, n)
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("Unknown plan %q, no plans are configured", name)
+		}
+		return nil, fmt.Errorf("Unknown plan %q, configured plans are: %s", name, strings.Join(sortedStrings(names), ", "))
+	}
+	return &plan, nil
+}
+
+// apply fills in the license fields defined by the plan
+func (plan *Plan) apply(info *lib.LicenseInfo) {
+	info.Features = append(info.Features, plan.Features...)
+
+	if len(plan.Limits) > 0 && info.Limits == nil {
+		info.Limits = make(map[string]int)
+	}
+	for k, v := range plan.Limits {
+		info.Limits[k] = v
+	}
+
+	if len(plan.Metadata) > 0 && info.Metadata == nil {
+		info.Metadata = make(map[string]string)
+	}
+	for k, v := range plan.Metadata {
+		info.Metadata[k] = v
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+	"github.com/dewaka/license_gen/lib/sqlitereg"
+)
+
+func defaultIssuer() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+func openRegistry(cfg *Config) (lib.Registry, error) {
+	if cfg.Registry == "" {
+		return nil, fmt.Errorf("Registry is disabled, set registry in the config file")
+	}
+	return sqlitereg.Open(cfg.Registry)
+}
+
+func runList(cfg *Config, args []string) error {
+	fs := newFlagSet("list", "")
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	return listLicenses(cfg, "")
+}
+
+func runSearch(cfg *Config, args []string) error {
+	fs := newFlagSet("search", "<query>")
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+
+	return listLicenses(cfg, fs.Arg(0))
+}
+
+func listLicenses(cfg *Config, query string) error {
+	reg, err := openRegistry(cfg)
+	if err != nil {
+		return err
+	}
+	defer reg.Close()
+
+	var res []*lib.IssuedLicense
+	if query != "" {
+		res, err = reg.Search(context.Background(), query)
+	} else {
+		res, err = reg.List(context.Background())
+	}
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tEXPIRY\tKEY ID\tISSUER\tISSUED")
+	for _, il := range res {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", il.ID, il.Name,
+			il.Expiration.Format("2006-01-02"), il.KeyID, il.Issuer,
+			il.IssuedAt.Local().Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+func runShow(cfg *Config, args []string) error {
+	fs := newFlagSet("show", "<license id>")
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+
+	reg, err := openRegistry(cfg)
+	if err != nil {
+		return err
+	}
+	defer reg.Close()
+
+	il, err := reg.Get(context.Background(), fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("ID:", il.ID)
+	fmt.Println("Licensee:", il.Name)
+	fmt.Println("Expiry date:", il.Expiration)
+	fmt.Println("Key ID:", il.KeyID)
+	fmt.Println("Issuer:", il.Issuer)
+	fmt.Println("Issued:", il.IssuedAt.Local())
+	fmt.Println("Updated:", il.UpdatedAt.Local())
+	fmt.Println("*** BEGIN LICENSE ***")
+	fmt.Print(string(il.Payload))
+	fmt.Println("\n*** END LICENSE ***")
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func runRenew(cfg *Config, args []string) error {
+	fs := newFlagSet("renew", "<license file>")
+	extend := fs.String("extend", "", "Duration to extend the current expiry by, such as 1y or 90d")
+	expDate := fs.String("expiry", "", "New expiry date. Expected format is 2006-1-02")
+	product := fs.String("product", "", "Product whose keys to use. Defaults to the product of the license.")
+	var features stringList
+	fs.Var(&features, "feature", "Feature to add. Can be repeated.")
+	limits := keyValues{}
+	fs.Var(limits, "limit", "Limit as name=number to add or change. Can be repeated.")
+	metadata := keyValues{}
+	fs.Var(metadata, "meta", "Metadata as key=value to add or change. Can be repeated.")
+	outFile := fs.String("o", "", "Renewed license file to write. Required, the license being renewed is kept.")
+	force := fs.Bool("force", false, "Overwrite the -o file if it exists")
+	format := fs.String("format", "", "Format of the renewed license: "+strings.Join(licenseFormats, ", ")+". Defaults to the format of the license being renewed.")
+	certKey := fs.String("cert", "", "Public key to verify the license with. Defaults to the configured public keys.")
+	crlFile := fs.String("crl", "", "Revocation list file or URL. Defaults to the configured revocation list, if it exists.")
+	privKey := fs.String("key", "", "Private key file. Defaults to the configured private key.")
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+	licFile := fs.Arg(0)
+
+	if (*extend == "") == (*expDate == "") {
+		return usagef("Exactly one of -extend or -expiry is required")
+	}
+	if *outFile == "" {
+		return usagef("-o is required")
+	}
+	if err := checkOverwrite(*force, *outFile); err != nil {
+		return err
+	}
+
+	if *format != "" && !contains(licenseFormats, *format) {
+		return usagef("Invalid -format %q, expected one of %s", *format, strings.Join(licenseFormats, ", "))
+	}
+
+	lic, inFormat, err := readLicenseFile(licFile)
+	if err != nil {
+		return fmt.Errorf("Read License failed: %w", err)
+	}
+	if *format == "" {
+		*format = inFormat
+	}
+
+	if *product == "" {
+		*product = lic.Info.Product
+	}
+	pc, err := cfg.forProduct(*product)
+	if err != nil {
+		return usagef("%s", err)
+	}
+	if *privKey == "" {
+		*privKey = pc.PrivateKey
+	}
+
+	if err := checkLicense(pc, lic, *certKey, *crlFile); err != nil {
+		return fmt.Errorf("%s: %w", licFile, err)
+	}
+
+	var expiry time.Time
+	if *extend != "" {
+		if expiry, err = addPeriod(lic.Info.Expiration, *extend); err != nil {
+			return usagef("Invalid -extend: %s", err)
+		}
+	} else if expiry, err = parseDate("expiry", *expDate); err != nil {
+		return err
+	}
+
+	if !expiry.After(lic.Info.Expiration) {
+		return usagef("New expiry %s is not after the current expiry %s",
+			expiry.Format("2006-01-02"), lic.Info.Expiration.Format("2006-01-02"))
+	}
+
+	renewed := lic.Renew(expiry)
+	if err := applyOverrides(&renewed.Info, features, limits, metadata); err != nil {
+		return err
+	}
+	if err := cfg.checkNames(renewed.Info.Product, renewed.Info.Features, renewed.Info.Limits); err != nil {
+		return usagef("%s", err)
+	}
+
+	logf("Renewing license %s for %s\n", lic.Info.ID, lic.Info.Name)
+	logf("New license ID: %s\n", renewed.Info.ID)
+	logf("Expiry date: %s -> %s\n", lic.Info.Expiration.Format("2006-01-02"), expiry.Format("2006-01-02"))
+	if len(renewed.Info.Features) > 0 {
+		logf("Features: %s\n", strings.Join(renewed.Info.Features, ", "))
+	}
+	for _, k := range sortedLimitNames(renewed.Info.Limits) {
+		logf("Limit %s: %d\n", k, renewed.Info.Limits[k])
+	}
+
+	pkey, err := lib.ReadPrivateKeyFromFile(*privKey)
+	if err != nil {
+		return err
+	}
+
+	if err := renewed.Sign(pkey); err != nil {
+		return err
+	}
+
+	s, err := openSinks(pc, pkey)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if err := s.publish(renewed); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(*outFile), 0755); err != nil {
+		return err
+	}
+
+	logf("Saving renewed License to: %s\n", *outFile)
+	if err := saveLicense(renewed, *outFile, *format, pkey, pc); err != nil {
+		return err
+	}
+
+	return s.record(renewed, lib.AuditRenew)
+}
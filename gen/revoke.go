@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func runRevoke(cfg *Config, args []string) error {
+	fs := newFlagSet("revoke", "")
+	product := fs.String("product", "", "Product whose revocation list and key to use")
+	crlFile := fs.String("crl", "", "Revocation list file. Defaults to the configured revocation list.")
+	revokeID := fs.String("id", "", "License ID to revoke")
+	revokeKeyID := fs.String("key-id", "", "Signing key ID to revoke")
+	reason := fs.String("reason", lib.ReasonUnspecified, "Revocation reason")
+	privKey := fs.String("key", "", "Private key file. Defaults to the configured private key.")
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	if *revokeID == "" && *revokeKeyID == "" {
+		return usagef("One of -id or -key-id is required")
+	}
+
+	pc, err := cfg.forProduct(*product)
+	if err != nil {
+		return usagef("%s", err)
+	}
+	if *crlFile == "" {
+		*crlFile = pc.RevocationList
+	}
+	if *privKey == "" {
+		*privKey = pc.PrivateKey
+	}
+
+	pkey, err := lib.ReadPrivateKeyFromFile(*privKey)
+	if err != nil {
+		return err
+	}
+
+	rl := lib.NewRevocationList()
+	if _, err := os.Stat(*crlFile); err == nil {
+		if rl, err = lib.ReadRevocationListFromFile(*crlFile); err != nil {
+			return err
+		}
+		if err := rl.Verify(&pkey.PublicKey); err != nil {
+			return fmt.Errorf("%s: %s", *crlFile, err)
+		}
+	}
+
+	if err := rl.Revoke(lib.Revocation{LicenseID: *revokeID, KeyID: *revokeKeyID, Reason: *reason}); err != nil {
+		return err
+	}
+
+	if err := rl.Sign(pkey); err != nil {
+		return err
+	}
+
+	logf("Revocation list sequence: %d\n", rl.Info.Sequence)
+	logf("Revoked entries: %d\n", len(rl.Info.Revocations))
+	logf("Saving revocation list to: %s\n", *crlFile)
+
+	if err := rl.SaveRevocationListToFile(*crlFile); err != nil {
+		return err
+	}
+
+	s, err := openSinks(pc, pkey)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	_, err = s.audit(lib.AuditEntry{
+		Action:    lib.AuditRevoke,
+		LicenseID: *revokeID,
+		KeyID:     *revokeKeyID,
+		Details: map[string]string{
+			"reason":   *reason,
+			"sequence": fmt.Sprint(rl.Info.Sequence),
+		},
+	})
+	return err
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+// sinks are the places an issued license is recorded in besides its license
+// file: the registry, the transparency log and the audit log. Each one is
+// optional and all of them are safe for concurrent use.
+type sinks struct {
+	cfg      *Config
+	pkey     *rsa.PrivateKey
+	registry lib.Registry
+	tlog     *lib.TransparencyLog
+	auditLog *lib.AuditLog
+}
+
+// openSinks opens the sinks enabled in the config. pkey signs tree heads and
+// audit checkpoints; without it audit entries are written unsigned and the
+// next entry written with a key becomes the checkpoint.
+func openSinks(cfg *Config, pkey *rsa.PrivateKey) (*sinks, error) {
+	s := &sinks{cfg: cfg, pkey: pkey}
+
+	var err error
+	if cfg.Registry != "" {
+		if s.registry, err = openRegistry(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.TransparencyLog != "" && pkey != nil {
+		if s.tlog, err = lib.OpenTransparencyLog(cfg.TransparencyLog); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	if cfg.AuditLog != "" {
+		if s.auditLog, err = lib.OpenAuditLog(cfg.AuditLog, pkey, cfg.AuditSignEvery); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Close signs a checkpoint of the audit entries written since the last one,
+// so that the audit log ends signed, and closes the registry
+func (s *sinks) Close() error {
+	if s.auditLog != nil {
+		if _, err := s.auditLog.Checkpoint(s.cfg.Issuer); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: writing audit log checkpoint failed: %s\n", err)
+		}
+	}
+	if s.registry != nil {
+		return s.registry.Close()
+	}
+	return nil
+}
+
+// publish appends a signed license to the transparency log, if enabled, and
+// embeds the inclusion proof in it
+func (s *sinks) publish(lic *lib.LicenseData) error {
+	if s.tlog == nil {
+		return nil
+	}
+
+	return s.tlog.Publish(lic, s.pkey)
+}
+
+// checkNewID returns lib.ErrDuplicateID if a license with id is already in
+// the registry, so that it is not issued again with a reused ID
+func (s *sinks) checkNewID(id string) error {
+	if s.registry == nil {
+		return nil
+	}
+
+	_, err := s.registry.Get(context.Background(), id)
+	switch {
+	case err == nil:
+		return fmt.Errorf("%w: %s", lib.ErrDuplicateID, id)
+	case errors.Is(err, lib.ErrLicenseNotFound):
+		return nil
+	}
+	return err
+}
+
+// record adds a saved license to the registry and the audit log
+func (s *sinks) record(lic *lib.LicenseData, action string) error {
+	if s.registry != nil {
+		il, err := lib.NewIssuedLicense(lic, s.cfg.Issuer)
+		if err != nil {
+			return err
+		}
+		if err := s.registry.Record(context.Background(), il); err != nil {
+			return fmt.Errorf("Recording license in registry failed: %w", err)
+		}
+	}
+
+	return s.auditLicense(lic, action)
+}
+
+// auditLicense adds an entry for an action on lic to the audit log
+func (s *sinks) auditLicense(lic *lib.LicenseData, action string) error {
+	details := map[string]string{
+		"name":       lic.Info.Name,
+		"expiration": lic.Info.Expiration.Format(time.RFC3339),
+	}
+	if lic.Info.Predecessor != "" {
+		details["predecessor"] = lic.Info.Predecessor
+	}
+
+	_, err := s.audit(lib.AuditEntry{
+		Action:    action,
+		LicenseID: lic.Info.ID,
+		KeyID:     lic.KeyID,
+		Details:   details,
+	})
+	return err
+}
+
+// audit appends an entry for a completed operation to the audit log, if
+// enabled
+func (s *sinks) audit(entry lib.AuditEntry) (*lib.AuditEntry, error) {
+	if s.auditLog == nil {
+		return nil, nil
+	}
+
+	entry.Actor = s.cfg.Issuer
+	e, err := s.auditLog.Append(entry)
+	if err != nil {
+		return nil, fmt.Errorf("Writing audit log failed: %s", err)
+	}
+
+	return e, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func runTlog(cfg *Config, args []string) error {
+	fs := newFlagSet("tlog head|consistency", "")
+	oldTreeSize := fs.Uint64("old-size", 0, "Older tree size to prove consistency from. Used by consistency.")
+	product := fs.String("product", "", "Product whose key signs tree heads. Defaults to the configured default product.")
+	privKey := fs.String("key", "", "Private key to sign tree heads with. Defaults to the configured private key of the product.")
+
+	sub, args, err := splitSubcommand("tlog", fs, args)
+	if err != nil {
+		return err
+	}
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	if cfg.TransparencyLog == "" {
+		return usagef("Transparency log is disabled, set transparency_log in the config file")
+	}
+
+	tl, err := lib.OpenTransparencyLog(cfg.TransparencyLog)
+	if err != nil {
+		return err
+	}
+
+	switch sub {
+	case "head":
+		// licenses of a product are checked against tree heads signed with
+		// the product key, as that is the key Publish uses
+		pc, err := cfg.forProduct(*product)
+		if err != nil {
+			return usagef("%s", err)
+		}
+		if *privKey == "" {
+			*privKey = pc.PrivateKey
+		}
+
+		pkey, err := lib.ReadPrivateKeyFromFile(*privKey)
+		if err != nil {
+			return err
+		}
+
+		sth, err := tl.TreeHead(tl.Size(), pkey)
+		if err != nil {
+			return err
+		}
+
+		return printJSON(sth)
+	case "consistency":
+		proof, err := tl.ConsistencyProof(*oldTreeSize, tl.Size())
+		if err != nil {
+			return err
+		}
+
+		return printJSON(proof)
+	default:
+		fs.Usage()
+		return usagef("Unknown tlog command: '%s'", sub)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func runVerify(cfg *Config, args []string) error {
+	fs := newFlagSet("verify", "<license file>")
+	product := fs.String("product", "", "Product the license must be for. Defaults to the product of the license, whose keys are used.")
+	certKey := fs.String("cert", "", "Public key file. Defaults to the configured public keys of the product.")
+	crlFile := fs.String("crl", "", "Revocation list file or URL. Defaults to the configured revocation list, if it exists.")
+	crlCert := fs.String("crl-cert", "", "Public key the revocation list must be signed with. Defaults to the trusted key named by the list.")
+	crlMinSeq := fs.Uint64("crl-min-seq", 0, "Reject revocation lists with a lower sequence number")
+	crlState := fs.String("crl-state", "", "File keeping the sequence of the last revocation list seen. Older lists are rejected.")
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+	licFile := fs.Arg(0)
+
+	lic, _, err := readLicenseFile(licFile)
+	if err != nil {
+		return fmt.Errorf("Read License failed: %w", err)
+	}
+
+	opts := []lib.VerifyOption{lib.WithMinRevocationSequence(*crlMinSeq)}
+	if *product != "" {
+		opts = append(opts, lib.WithRequiredProduct(*product))
+	} else {
+		*product = lic.Info.Product
+	}
+	if *crlState != "" {
+		opts = append(opts, lib.WithRevocationStateFile(*crlState))
+	}
+	if *crlCert != "" {
+		crlKey, err := lib.ReadPublicKeyFromFile(*crlCert)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, lib.WithRevocationKey(crlKey))
+	}
+
+	pc, err := cfg.forProduct(*product)
+	if err != nil {
+		return usagef("%s", err)
+	}
+	keys, err := trustedKeys(pc, *certKey)
+	if err != nil {
+		return err
+	}
+
+	vl, err := licenseVerifier(pc, keys, *crlFile, opts...).VerifyLicense(context.Background(), lic)
+	if err != nil {
+		return err
+	}
+	logf("License verified with key %s\n", vl.KeyID)
+
+	if lic.Proof != nil {
+		publicKey := keys.Lookup(vl.KeyID)
+		if publicKey == nil {
+			return lib.ErrNoInclusionProof
+		}
+		if err := lic.VerifyInclusion(publicKey); err != nil {
+			return err
+		}
+		logf("Transparency log inclusion verified (leaf %d of %d)\n", lic.Proof.LeafIndex, lic.Proof.TreeHead.Head.TreeSize)
+	}
+
+	fmt.Println("License OK")
+	return nil
+}
+
+// trustedKeys returns certKey or, when empty, the configured public key of pc
+// and its product public key for COSE licenses
+func trustedKeys(pc *Config, certKey string) (*lib.TrustStore, error) {
+	keys, err := lib.NewTrustStore()
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{certKey}
+	if certKey == "" {
+		files = []string{pc.PublicKey, pc.ProductPublicKey}
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) && certKey == "" {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := keys.AddPEM(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	if keys.Len() == 0 {
+		return nil, fmt.Errorf("None of the configured public keys exist: %s", strings.Join(files, ", "))
+	}
+
+	return keys, nil
+}
+
+// licenseVerifier returns a Verifier for licenses of the product pc signed
+// by keys. Revocation is checked against crlFile or, when empty, the
+// configured revocation list if it exists.
+func licenseVerifier(pc *Config, keys *lib.TrustStore, crlFile string, opts ...lib.VerifyOption) *lib.Verifier {
+	if crlFile == "" {
+		if _, err := os.Stat(pc.RevocationList); err == nil {
+			crlFile = pc.RevocationList
+		}
+	}
+
+	opts = append([]lib.VerifyOption{lib.WithTrustStore(keys)}, opts...)
+	if crlFile != "" {
+		opts = append(opts, lib.WithRevocationListFrom(crlFile))
+	}
+	return lib.NewVerifier(opts...)
+}
+
+// checkLicense verifies a license being renewed or converted and that it has
+// not been revoked. Expired licenses pass.
+func checkLicense(pc *Config, lic *lib.LicenseData, certKey, crlFile string) error {
+	keys, err := trustedKeys(pc, certKey)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range licenseVerifier(pc, keys, crlFile).Validate(context.Background(), lic).Problems {
+		if p.Code != lib.CodeExpired && p.Code != lib.CodeNotYetValid {
+			return p
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// License encodings recognised by ReadLicense
+const (
+	FormatJSON  = "json"
+	FormatArmor = "armor"
+	FormatJWT   = "jwt"
+	FormatCOSE  = "cose"
+)
+
+// ArmorType is the PEM type of armored licenses
+const ArmorType = "LICENSE"
+
+// ErrInvalidArmor is returned for an armored license that cannot be decoded
+var ErrInvalidArmor = errors.New("Invalid armored license")
+
+var armorPrefix = []byte("-----BEGIN " + ArmorType + "-----")
+
+// DetectFormat returns the encoding of a license, FormatJSON when nothing
+// else matches
+func DetectFormat(data []byte) string {
+	if len(data) > 0 && data[0] == 0xc0|coseSign1Tag {
+		return FormatCOSE
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, armorPrefix):
+		return FormatArmor
+	case isJWT(trimmed):
+		return FormatJWT
+	case len(trimmed) == 0 || trimmed[0] == '{':
+		return FormatJSON
+	case isTOML(trimmed):
+		return FormatTOML
+	case isYAML(trimmed):
+		return FormatYAML
+	}
+	return FormatJSON
+}
+
+// Armor encodes the license as a PEM style text block which survives being
+// pasted into emails and forms. The headers repeat the licensee, expiry and key
+// ID for people reading the block; they are not signed and ignored on read.
+func (lic *LicenseData) Armor() ([]byte, error) {
+	body, err := json.Marshal(lic)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := map[string]string{
+		"Licensee": lic.Info.Name,
+		"Expires":  lic.Info.Expiration.Format(time.RFC3339),
+	}
+	if lic.Info.ID != "" {
+		headers["License-ID"] = lic.Info.ID
+	}
+	if lic.Info.Product != "" {
+		headers["Product"] = lic.Info.Product
+	}
+	if lic.KeyID != "" {
+		headers["Key-ID"] = lic.KeyID
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: ArmorType, Headers: headers, Bytes: body}), nil
+}
+
+// WriteArmored writes the armored license to w
+func (lic *LicenseData) WriteArmored(w io.Writer) error {
+	data, err := lic.Armor()
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// SaveArmoredLicenseToFile writes the armored license to a file
+func (lic *LicenseData) SaveArmoredLicenseToFile(licName string) error {
+	data, err := lic.Armor()
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(licName, data, 0644)
+}
+
+func decodeArmor(data []byte) (*LicenseData, error) {
+	block, _ := pem.Decode(bytes.TrimSpace(data))
+	if block == nil || block.Type != ArmorType {
+		return nil, ErrInvalidArmor
+	}
+
+	var license LicenseData
+	if err := json.Unmarshal(block.Bytes, &license); err != nil {
+		return nil, ErrInvalidArmor
+	}
+
+	return &license, nil
+}
+
+// DecodeQRPayload reads the armored license held in a scanned QR code.
+// Scanners may return its line breaks as CRLF. QR codes of product keys are
+// read with DecodeProductKey.
+func DecodeQRPayload(payload string) (*LicenseData, error) {
+	data := bytes.Replace([]byte(payload), []byte("\r\n"), []byte("\n"), -1)
+	return decodeArmor(data)
+}
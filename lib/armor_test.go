@@ -0,0 +1,80 @@
+package lib_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func TestArmoredLicense(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+
+	lic := lib.NewLicense("Chathura Colombage", time.Now().Add(24*time.Hour))
+	lic.Info.Features = []string{"sso"}
+	if err := lic.Sign(pkey); err != nil {
+		t.Fatal("Failed to sign license:", err)
+	}
+
+	armored, err := lic.Armor()
+	if err != nil {
+		t.Fatal("Failed to armor license:", err)
+	}
+	if !bytes.HasPrefix(armored, []byte("-----BEGIN LICENSE-----\nExpires: ")) ||
+		!bytes.Contains(armored, []byte("Licensee: Chathura Colombage\n")) {
+		t.Errorf("Unexpected armored license:\n%s", armored)
+	}
+
+	// pasted blocks often come with surrounding blank lines and indentation
+	pasted := "\n\n  " + string(armored) + "\n"
+	if lib.DetectFormat([]byte(pasted)) != lib.FormatArmor {
+		t.Error("Expected armored format to be detected")
+	}
+
+	err = lib.CheckLicense(strings.NewReader(pasted), strings.NewReader(pubKey))
+	if err != nil {
+		t.Error("Expected nil error, but found", err)
+	}
+
+	tampered := bytes.Replace(armored, []byte("Licensee: Chathura"), []byte("Licensee: Someone"), 1)
+	read, err := lib.ReadLicense(bytes.NewReader(tampered))
+	if err != nil || read.Info.Name != "Chathura Colombage" {
+		t.Error("Expected headers to be ignored, but found", read, err)
+	}
+}
+
+func TestQRPayload(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+
+	lic := lib.NewLicense("Chathura Colombage", time.Now().Add(24*time.Hour))
+	lic.Info.Features = []string{"kiosk"}
+	if err := lic.Sign(pkey); err != nil {
+		t.Fatal("Failed to sign license:", err)
+	}
+
+	armored, err := lic.Armor()
+	if err != nil {
+		t.Fatal("Failed to armor license:", err)
+	}
+	scanned := strings.Replace(string(armored), "\n", "\r\n", -1)
+
+	read, err := lib.DecodeQRPayload(scanned)
+	if err != nil {
+		t.Fatal("Failed to decode QR payload:", err)
+	}
+	if read.Info.ID != lic.Info.ID || !read.Info.HasFeature("kiosk") {
+		t.Error("Expected", lic.Info, "but found", read.Info)
+	}
+
+	if _, err := lib.DecodeQRPayload(`{"info":{}}`); err != lib.ErrInvalidArmor {
+		t.Error("Expected ErrInvalidArmor, but found", err)
+	}
+}
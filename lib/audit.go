@@ -0,0 +1,354 @@
+package lib
+
+import (
+	"bufio"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Audit log actions
+const (
+	AuditIssue      = "issue"
+	AuditRenew      = "renew"
+	AuditConvert    = "convert"
+	AuditRevoke     = "revoke"
+	AuditKeyGen     = "keygen"
+	AuditCheckpoint = "checkpoint"
+)
+
+// DefaultAuditSignEvery is how many entries are appended between signed
+// checkpoints when no interval is given
+const DefaultAuditSignEvery = 16
+
+// Audit log verification errors
+var (
+	ErrAuditSequence  = errors.New("Audit log entry missing or out of order")
+	ErrAuditChain     = errors.New("Audit log hash chain broken")
+	ErrAuditHash      = errors.New("Audit log entry modified")
+	ErrAuditSignature = errors.New("Audit log signature invalid")
+	ErrAuditUnsigned  = errors.New("Audit log checkpoint missing")
+)
+
+// AuditEntry - A single record of the append-only audit log. Hash covers
+// every other field except the signature, including the hash of the previous
+// entry, so entries cannot be modified, removed or reordered without breaking
+// the chain.
+type AuditEntry struct {
+	Seq       uint64            `json:"seq"`
+	Time      time.Time         `json:"time"`
+	Actor     string            `json:"actor"`
+	Action    string            `json:"action"`
+	LicenseID string            `json:"license_id,omitempty"`
+	KeyID     string            `json:"key_id,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	PrevHash  string            `json:"prev_hash"`
+	Hash      string            `json:"hash"`
+
+	// Signature over Hash, present on checkpoint entries only
+	SignerKeyID string `json:"signer_key_id,omitempty"`
+	Signature   string `json:"signature,omitempty"`
+}
+
+func (e *AuditEntry) computeHash() (string, error) {
+	content := *e
+	content.Hash = ""
+	content.SignerKeyID = ""
+	content.Signature = ""
+
+	data, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuditLog appends hash-chained entries to a JSON lines file. It is safe for
+// concurrent use within a process and, where file locks are supported, by
+// several processes appending to the same file.
+type AuditLog struct {
+	path      string
+	key       *rsa.PrivateKey
+	signEvery uint64
+
+	mu         sync.Mutex
+	size       int64
+	last       *AuditEntry
+	lastSigned uint64
+}
+
+// OpenAuditLog opens the audit log at path, creating it on first append.
+// When key is not nil an entry is signed whenever signEvery entries have been
+// appended since the last signed one.
+func OpenAuditLog(path string, key *rsa.PrivateKey, signEvery int) (*AuditLog, error) {
+	if signEvery <= 0 {
+		signEvery = DefaultAuditSignEvery
+	}
+	log := &AuditLog{path: path, key: key, signEvery: uint64(signEvery)}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return log, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if err := lockFile(file); err != nil {
+		return nil, err
+	}
+	if err := log.readNew(file); err != nil {
+		return nil, err
+	}
+
+	return log, nil
+}
+
+// readNew reads the entries appended to file since it was last read, by this
+// or another process. file must be locked.
+func (l *AuditLog) readNew(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < l.size {
+		return fmt.Errorf("%w: %s was truncated", ErrAuditSequence, l.path)
+	}
+
+	err = readAuditEntries(io.NewSectionReader(file, l.size, info.Size()-l.size), func(e *AuditEntry) error {
+		l.last = e
+		if e.Signature != "" {
+			l.lastSigned = e.Seq
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	l.size = info.Size()
+	return nil
+}
+
+// Append fills in the sequence number, time and hashes of e and writes it to
+// the log
+func (l *AuditLog) Append(e AuditEntry) (*AuditEntry, error) {
+	return l.append(e, false)
+}
+
+// Checkpoint appends a signed checkpoint entry when entries have been
+// appended since the last signed one, so that none of them can be removed
+// without detection. Writers call it when they are done. It does nothing
+// without a key.
+func (l *AuditLog) Checkpoint(actor string) (*AuditEntry, error) {
+	if l.key == nil {
+		return nil, nil
+	}
+	return l.append(AuditEntry{Actor: actor, Action: AuditCheckpoint}, true)
+}
+
+func (l *AuditLog) append(e AuditEntry, checkpoint bool) (*AuditEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if err := lockFile(file); err != nil {
+		return nil, err
+	}
+	if err := l.readNew(file); err != nil {
+		return nil, err
+	}
+	if checkpoint && (l.last == nil || l.last.Seq == l.lastSigned) {
+		return nil, nil
+	}
+
+	e.Seq = 1
+	e.PrevHash = ""
+	if l.last != nil {
+		e.Seq = l.last.Seq + 1
+		e.PrevHash = l.last.Hash
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	e.SignerKeyID = ""
+	e.Signature = ""
+
+	hash, err := e.computeHash()
+	if err != nil {
+		return nil, err
+	}
+	e.Hash = hash
+
+	if l.key != nil && (checkpoint || e.Seq-l.lastSigned >= l.signEvery) {
+		if err := e.sign(l.key); err != nil {
+			return nil, err
+		}
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := file.Write(append(line, '\n'))
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Sync(); err != nil {
+		return nil, err
+	}
+
+	l.size += int64(n)
+	l.last = &e
+	if e.Signature != "" {
+		l.lastSigned = e.Seq
+	}
+
+	return &e, nil
+}
+
+func (e *AuditEntry) sign(key *rsa.PrivateKey) error {
+	sig, err := Sign(key, []byte(e.Hash))
+	if err != nil {
+		return err
+	}
+
+	keyID, err := KeyID(&key.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	e.Signature = encodeKey(sig)
+	e.SignerKeyID = keyID
+	return nil
+}
+
+func readAuditEntries(r io.Reader, fn func(*AuditEntry) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("Audit log line %d: %s", line, err)
+		}
+		if err := fn(&e); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// AuditReport - Summary of a verified audit log
+type AuditReport struct {
+	Entries       uint64
+	Signed        uint64
+	LastSignedSeq uint64
+	LastHash      string
+}
+
+// UnsignedTail is the number of entries after the last signed checkpoint.
+// Those entries could have been truncated without detection.
+func (r *AuditReport) UnsignedTail() uint64 {
+	return r.Entries - r.LastSignedSeq
+}
+
+// VerifyAuditLog checks the sequence numbers, hash chain and checkpoint
+// signatures of the log read from r. Each checkpoint is verified with the key
+// of keys matching its signer key ID, as products with their own keys sign
+// their own checkpoints. The hash chain alone can be recomputed by anyone, so
+// when keys is set a run of signEvery entries without a signed checkpoint is
+// an error, as the log writer never leaves one. Use the signEvery the log was
+// written with, DefaultAuditSignEvery when it is 0. When keys is nil
+// signatures are not checked.
+func VerifyAuditLog(r io.Reader, keys *TrustStore, signEvery int) (*AuditReport, error) {
+	if signEvery <= 0 {
+		signEvery = DefaultAuditSignEvery
+	}
+
+	report := &AuditReport{}
+	var prev *AuditEntry
+
+	err := readAuditEntries(r, func(e *AuditEntry) error {
+		wantSeq, wantPrev := uint64(1), ""
+		if prev != nil {
+			wantSeq, wantPrev = prev.Seq+1, prev.Hash
+		}
+
+		if e.Seq != wantSeq {
+			return fmt.Errorf("%w: expected entry %d, found %d", ErrAuditSequence, wantSeq, e.Seq)
+		}
+		if e.PrevHash != wantPrev {
+			return fmt.Errorf("%w: at entry %d", ErrAuditChain, e.Seq)
+		}
+
+		hash, err := e.computeHash()
+		if err != nil {
+			return err
+		}
+		if hash != e.Hash {
+			return fmt.Errorf("%w: entry %d", ErrAuditHash, e.Seq)
+		}
+
+		if e.Signature != "" {
+			if keys != nil {
+				publicKey := keys.Lookup(e.SignerKeyID)
+				if publicKey == nil {
+					return fmt.Errorf("%w: entry %d is signed by unknown key %s", ErrAuditSignature, e.Seq, e.SignerKeyID)
+				}
+				sig, err := decodeKey(e.Signature)
+				if err != nil || Unsign(publicKey, []byte(e.Hash), sig) != nil {
+					return fmt.Errorf("%w: entry %d", ErrAuditSignature, e.Seq)
+				}
+			}
+			report.Signed++
+			report.LastSignedSeq = e.Seq
+		} else if keys != nil && e.Seq-report.LastSignedSeq >= uint64(signEvery) {
+			return fmt.Errorf("%w: entries %d to %d are unsigned", ErrAuditUnsigned, report.LastSignedSeq+1, e.Seq)
+		}
+
+		report.Entries++
+		report.LastHash = e.Hash
+		prev = e
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+// VerifyAuditLogFile verifies the audit log stored at path
+func VerifyAuditLogFile(path string, keys *TrustStore, signEvery int) (*AuditReport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return VerifyAuditLog(file, keys, signEvery)
+}
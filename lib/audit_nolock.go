@@ -0,0 +1,11 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package lib
+
+import "os"
+
+// lockFile does nothing where flock is not available. Only one process may
+// append to an audit log at a time there.
+func lockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,210 @@
+package lib_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func writeTestAuditLog(t *testing.T, entries int) (string, []string) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	for i := 0; i < entries; i++ {
+		// reopen every time to make sure the chain continues across runs
+		log, err := lib.OpenAuditLog(path, pkey, 2)
+		if err != nil {
+			t.Fatal("Failed to open audit log:", err)
+		}
+		if _, err := log.Append(lib.AuditEntry{Actor: "ops", Action: lib.AuditIssue, LicenseID: string(rune('a' + i))}); err != nil {
+			t.Fatal("Failed to append audit entry:", err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path, strings.SplitAfter(strings.TrimSpace(string(data)), "\n")
+}
+
+func testAuditKeys(t *testing.T) *lib.TrustStore {
+	pub, err := lib.ReadPublicKey(strings.NewReader(pubKey))
+	if err != nil {
+		t.Fatal("Failed to read public key:", err)
+	}
+	keys, err := lib.NewTrustStore(pub)
+	if err != nil {
+		t.Fatal("Failed to create trust store:", err)
+	}
+	return keys
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	keys := testAuditKeys(t)
+
+	path, _ := writeTestAuditLog(t, 5)
+	report, err := lib.VerifyAuditLogFile(path, keys, 2)
+	if err != nil {
+		t.Fatal("Expected audit log to verify, but found", err)
+	}
+
+	if report.Entries != 5 || report.Signed != 2 || report.UnsignedTail() != 1 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+}
+
+func TestVerifyAuditLogTampering(t *testing.T) {
+	keys := testAuditKeys(t)
+
+	_, lines := writeTestAuditLog(t, 4)
+
+	deleted := strings.Join(append(append([]string{}, lines[:1]...), lines[2:]...), "")
+	if _, err := lib.VerifyAuditLog(strings.NewReader(deleted), keys, 2); !errors.Is(err, lib.ErrAuditSequence) {
+		t.Error("Expected ErrAuditSequence for a deleted entry, but found", err)
+	}
+
+	modified := strings.Join(lines, "")
+	modified = strings.Replace(modified, `"license_id":"b"`, `"license_id":"x"`, 1)
+	if _, err := lib.VerifyAuditLog(strings.NewReader(modified), keys, 2); !errors.Is(err, lib.ErrAuditHash) {
+		t.Error("Expected ErrAuditHash for a modified entry, but found", err)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(strings.Replace(strings.Join(lines, ""), `"signature":"`, `"signature":"AAAA`, 1))
+	if _, err := lib.VerifyAuditLog(&buf, keys, 2); !errors.Is(err, lib.ErrAuditSignature) {
+		t.Error("Expected ErrAuditSignature for a forged signature, but found", err)
+	}
+}
+
+func TestVerifyAuditLogStrippedSignatures(t *testing.T) {
+	keys := testAuditKeys(t)
+
+	path, lines := writeTestAuditLog(t, 4)
+
+	// without signatures the chain still links up, but the checkpoints are gone
+	var stripped []*lib.AuditEntry
+	for _, line := range lines {
+		var e lib.AuditEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		e.SignerKeyID, e.Signature = "", ""
+		stripped = append(stripped, &e)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range stripped {
+		enc.Encode(e)
+	}
+
+	if _, err := lib.VerifyAuditLog(bytes.NewReader(buf.Bytes()), keys, 2); !errors.Is(err, lib.ErrAuditUnsigned) {
+		t.Error("Expected ErrAuditUnsigned for stripped signatures, but found", err)
+	}
+	if _, err := lib.VerifyAuditLog(bytes.NewReader(buf.Bytes()), nil, 2); err != nil {
+		t.Error("Expected stripped log to verify without a key, but found", err)
+	}
+
+	// a log written with a larger interval fails a stricter check
+	if _, err := lib.VerifyAuditLogFile(path, keys, 1); !errors.Is(err, lib.ErrAuditUnsigned) {
+		t.Error("Expected ErrAuditUnsigned for a shorter interval, but found", err)
+	}
+}
+
+func TestVerifyAuditLogSignerKeys(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+	productKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal("Failed to generate key:", err)
+	}
+
+	// products with their own keys sign checkpoints in the same log
+	path := filepath.Join(t.TempDir(), "audit.log")
+	for i, key := range []*rsa.PrivateKey{pkey, pkey, productKey, productKey} {
+		log, err := lib.OpenAuditLog(path, key, 2)
+		if err != nil {
+			t.Fatal("Failed to open audit log:", err)
+		}
+		if _, err := log.Append(lib.AuditEntry{Actor: "ops", Action: lib.AuditIssue, LicenseID: string(rune('a' + i))}); err != nil {
+			t.Fatal("Failed to append audit entry:", err)
+		}
+	}
+
+	keys := testAuditKeys(t)
+	if _, err := lib.VerifyAuditLogFile(path, keys, 2); !errors.Is(err, lib.ErrAuditSignature) {
+		t.Error("Expected ErrAuditSignature without the product key, but found", err)
+	}
+
+	keys.Add(&productKey.PublicKey)
+	report, err := lib.VerifyAuditLogFile(path, keys, 2)
+	if err != nil {
+		t.Fatal("Expected audit log to verify with both keys, but found", err)
+	}
+	if report.Signed != 2 {
+		t.Errorf("Expected 2 signed checkpoints, but found %d", report.Signed)
+	}
+}
+
+func TestAuditLogCheckpoint(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+
+	// two writers of the same file, as concurrent lgen runs are, keep one
+	// chain
+	path := filepath.Join(t.TempDir(), "audit.log")
+	first, err := lib.OpenAuditLog(path, pkey, 16)
+	if err != nil {
+		t.Fatal("Failed to open audit log:", err)
+	}
+	second, err := lib.OpenAuditLog(path, pkey, 16)
+	if err != nil {
+		t.Fatal("Failed to open audit log:", err)
+	}
+	for i, log := range []*lib.AuditLog{first, second, first, second} {
+		if _, err := log.Append(lib.AuditEntry{Actor: "ops", Action: lib.AuditIssue, LicenseID: string(rune('a' + i))}); err != nil {
+			t.Fatal("Failed to append audit entry:", err)
+		}
+	}
+
+	keys := testAuditKeys(t)
+	report, err := lib.VerifyAuditLogFile(path, keys, 16)
+	if err != nil {
+		t.Fatal("Expected audit log to verify, but found", err)
+	}
+	if report.UnsignedTail() != 4 {
+		t.Errorf("Expected 4 unsigned entries, but found %d", report.UnsignedTail())
+	}
+
+	e, err := first.Checkpoint("ops")
+	if err != nil || e == nil || e.Seq != 5 || e.Signature == "" {
+		t.Fatal("Expected signed checkpoint entry 5, but found", e, err)
+	}
+	if e, err := second.Checkpoint("ops"); err != nil || e != nil {
+		t.Error("Expected no checkpoint after a checkpoint, but found", e, err)
+	}
+
+	report, err = lib.VerifyAuditLogFile(path, keys, 16)
+	if err != nil {
+		t.Fatal("Expected audit log to verify, but found", err)
+	}
+	if report.UnsignedTail() != 0 || report.Signed != 1 {
+		t.Errorf("Expected a signed tail, but found %+v", report)
+	}
+}
@@ -0,0 +1,228 @@
+package lib
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// A minimal CBOR (RFC 8949) encoder and decoder for COSE licenses. Only
+// definite length integers, byte and text strings, arrays, maps and tags are
+// supported, which is all a license needs.
+
+// errCBOR is returned for malformed or unsupported CBOR
+var errCBOR = errors.New("Invalid CBOR")
+
+// CBOR major types
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+)
+
+type cborWriter struct {
+	buf []byte
+}
+
+func (w *cborWriter) head(major byte, n uint64) {
+	major <<= 5
+	switch {
+	case n < 24:
+		w.buf = append(w.buf, major|byte(n))
+	case n <= 0xff:
+		w.buf = append(w.buf, major|24, byte(n))
+	case n <= 0xffff:
+		w.buf = append(w.buf, major|25, 0, 0)
+		binary.BigEndian.PutUint16(w.buf[len(w.buf)-2:], uint16(n))
+	case n <= 0xffffffff:
+		w.buf = append(w.buf, major|26, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(w.buf[len(w.buf)-4:], uint32(n))
+	default:
+		w.buf = append(w.buf, major|27, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(w.buf[len(w.buf)-8:], n)
+	}
+}
+
+func (w *cborWriter) int(n int64) {
+	if n < 0 {
+		w.head(cborNegInt, uint64(-1-n))
+		return
+	}
+	w.head(cborUint, uint64(n))
+}
+
+func (w *cborWriter) bytes(b []byte) {
+	w.head(cborBytes, uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *cborWriter) text(s string) {
+	w.head(cborText, uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *cborWriter) texts(list []string) {
+	w.head(cborArray, uint64(len(list)))
+	for _, s := range list {
+		w.text(s)
+	}
+}
+
+// sortedKeys returns map keys in the canonical CBOR order of their encoding,
+// shorter keys first
+func sortedKeys(keys []string) []string {
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) < len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+type cborReader struct {
+	data []byte
+	off  int
+}
+
+func (r *cborReader) head() (byte, uint64, error) {
+	if r.off >= len(r.data) {
+		return 0, 0, errCBOR
+	}
+	b := r.data[r.off]
+	r.off++
+
+	major, info := b>>5, b&0x1f
+	size := 0
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		// indefinite lengths and simple values are not used by licenses
+		return 0, 0, errCBOR
+	}
+	if len(r.data)-r.off < size {
+		return 0, 0, errCBOR
+	}
+
+	var n uint64
+	for _, c := range r.data[r.off : r.off+size] {
+		n = n<<8 | uint64(c)
+	}
+	r.off += size
+	return major, n, nil
+}
+
+func (r *cborReader) expect(major byte) (uint64, error) {
+	m, n, err := r.head()
+	if err != nil {
+		return 0, err
+	}
+	if m != major {
+		return 0, errCBOR
+	}
+	return n, nil
+}
+
+func (r *cborReader) int() (int64, error) {
+	m, n, err := r.head()
+	if err != nil {
+		return 0, err
+	}
+	if n > 1<<63-1 {
+		return 0, errCBOR
+	}
+	switch m {
+	case cborUint:
+		return int64(n), nil
+	case cborNegInt:
+		return -1 - int64(n), nil
+	}
+	return 0, errCBOR
+}
+
+// bytes returns a byte or text string of the given major type, sharing the
+// underlying data
+func (r *cborReader) bytes(major byte) ([]byte, error) {
+	n, err := r.expect(major)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(r.data)-r.off) < n {
+		return nil, errCBOR
+	}
+	b := r.data[r.off : r.off+int(n)]
+	r.off += int(n)
+	return b, nil
+}
+
+func (r *cborReader) text() (string, error) {
+	b, err := r.bytes(cborText)
+	return string(b), err
+}
+
+func (r *cborReader) texts() ([]string, error) {
+	n, err := r.expect(cborArray)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.data)-r.off) {
+		return nil, errCBOR
+	}
+
+	list := make([]string, 0, n)
+	for i := uint64(0); i < n; i++ {
+		s, err := r.text()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+// skip reads over one data item of any supported type
+func (r *cborReader) skip() error {
+	m, n, err := r.head()
+	if err != nil {
+		return err
+	}
+
+	switch m {
+	case cborBytes, cborText:
+		if uint64(len(r.data)-r.off) < n {
+			return errCBOR
+		}
+		r.off += int(n)
+	case cborArray, cborMap:
+		if n > uint64(len(r.data)-r.off) {
+			return errCBOR
+		}
+		if m == cborMap {
+			n *= 2
+		}
+		for i := uint64(0); i < n; i++ {
+			if err := r.skip(); err != nil {
+				return err
+			}
+		}
+	case cborTag:
+		return r.skip()
+	case cborUint, cborNegInt:
+	default:
+		return errCBOR
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// License file formats besides JSON
+const (
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+// ErrUnknownFormat is returned for a license format without a Codec
+var ErrUnknownFormat = errors.New("Unknown license format")
+
+// Codec - A serialization of license files. Every codec keeps the signature
+// of the license, which is computed over CanonicalPayload rather than the
+// encoded text, so a license converted between codecs still verifies.
+type Codec interface {
+	// Format is the name DetectFormat returns for the encoding
+	Format() string
+	Encode(lic *LicenseData) ([]byte, error)
+	Decode(data []byte) (*LicenseData, error)
+}
+
+// Codecs of the license formats which keep the signature of the license
+var (
+	JSONCodec  Codec = jsonCodec{}
+	YAMLCodec  Codec = yamlCodec{}
+	TOMLCodec  Codec = tomlCodec{}
+	ArmorCodec Codec = armorCodec{}
+)
+
+// CodecFor returns the Codec of a license format. JWT and COSE licenses carry
+// their own signature and have no Codec.
+func CodecFor(format string) (Codec, error) {
+	for _, c := range []Codec{JSONCodec, YAMLCodec, TOMLCodec, ArmorCodec} {
+		if c.Format() == format {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+}
+
+// CanonicalPayload returns the bytes the license signature is computed over,
+// the license information as compact JSON
+func (lic *LicenseData) CanonicalPayload() ([]byte, error) {
+	return json.Marshal(lic.Info)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Format() string { return FormatJSON }
+
+func (jsonCodec) Encode(lic *LicenseData) ([]byte, error) {
+	return json.MarshalIndent(lic, "", "  ")
+}
+
+func (jsonCodec) Decode(data []byte) (*LicenseData, error) {
+	var license LicenseData
+	if err := json.Unmarshal(data, &license); err != nil {
+		return nil, err
+	}
+	return &license, nil
+}
+
+type yamlCodec struct{}
+
+func (yamlCodec) Format() string { return FormatYAML }
+
+func (yamlCodec) Encode(lic *LicenseData) ([]byte, error) {
+	return yaml.Marshal(newTextLicense(lic))
+}
+
+func (yamlCodec) Decode(data []byte) (*LicenseData, error) {
+	var license textLicense
+	if err := yaml.Unmarshal(data, &license); err != nil {
+		return nil, err
+	}
+	return license.licenseData(), nil
+}
+
+type tomlCodec struct{}
+
+func (tomlCodec) Format() string { return FormatTOML }
+
+func (tomlCodec) Encode(lic *LicenseData) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(newTextLicense(lic)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (tomlCodec) Decode(data []byte) (*LicenseData, error) {
+	var license textLicense
+	if _, err := toml.Decode(string(data), &license); err != nil {
+		return nil, err
+	}
+	return license.licenseData(), nil
+}
+
+// textLicense is a license as written by the YAML and TOML codecs, which
+// would otherwise write the hashes of the inclusion proof as lists of
+// integers. Hashes are base64 strings, as in JSON licenses.
+type textLicense struct {
+	Info  LicenseInfo `yaml:"info" toml:"info"`
+	KeyID string      `yaml:"key_id,omitempty" toml:"key_id,omitempty"`
+	Key   string      `yaml:"key" toml:"key"`
+	Proof *textProof  `yaml:"proof,omitempty" toml:"proof,omitempty"`
+}
+
+type textProof struct {
+	LeafIndex uint64        `yaml:"leaf_index" toml:"leaf_index"`
+	Hashes    []textHash    `yaml:"hashes" toml:"hashes"`
+	TreeHead  *textTreeHead `yaml:"tree_head" toml:"tree_head"`
+}
+
+type textTreeHead struct {
+	Head struct {
+		TreeSize  uint64    `yaml:"tree_size" toml:"tree_size"`
+		RootHash  textHash  `yaml:"root_hash" toml:"root_hash"`
+		Timestamp time.Time `yaml:"timestamp" toml:"timestamp"`
+	} `yaml:"head" toml:"head"`
+	KeyID string `yaml:"key_id,omitempty" toml:"key_id,omitempty"`
+	Key   string `yaml:"key" toml:"key"`
+}
+
+// textHash is a hash encoded as a base64 string
+type textHash []byte
+
+func (h textHash) MarshalText() ([]byte, error) {
+	return []byte(base64.StdEncoding.EncodeToString(h)), nil
+}
+
+func (h *textHash) UnmarshalText(text []byte) error {
+	b, err := base64.StdEncoding.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	*h = b
+	return nil
+}
+
+func newTextLicense(lic *LicenseData) *textLicense {
+	tl := &textLicense{Info: lic.Info, KeyID: lic.KeyID, Key: lic.Key}
+	if lic.Proof == nil {
+		return tl
+	}
+
+	tl.Proof = &textProof{LeafIndex: lic.Proof.LeafIndex}
+	for _, h := range lic.Proof.Hashes {
+		tl.Proof.Hashes = append(tl.Proof.Hashes, h)
+	}
+	if sth := lic.Proof.TreeHead; sth != nil {
+		tl.Proof.TreeHead = &textTreeHead{KeyID: sth.KeyID, Key: sth.Key}
+		tl.Proof.TreeHead.Head.TreeSize = sth.Head.TreeSize
+		tl.Proof.TreeHead.Head.RootHash = sth.Head.RootHash
+		tl.Proof.TreeHead.Head.Timestamp = sth.Head.Timestamp
+	}
+	return tl
+}
+
+func (tl *textLicense) licenseData() *LicenseData {
+	lic := &LicenseData{Info: tl.Info, KeyID: tl.KeyID, Key: tl.Key}
+	if tl.Proof == nil {
+		return lic
+	}
+
+	lic.Proof = &InclusionProof{LeafIndex: tl.Proof.LeafIndex}
+	for _, h := range tl.Proof.Hashes {
+		lic.Proof.Hashes = append(lic.Proof.Hashes, h)
+	}
+	if th := tl.Proof.TreeHead; th != nil {
+		lic.Proof.TreeHead = &SignedTreeHead{
+			Head: TreeHead{
+				TreeSize:  th.Head.TreeSize,
+				RootHash:  th.Head.RootHash,
+				Timestamp: th.Head.Timestamp,
+			},
+			KeyID: th.KeyID,
+			Key:   th.Key,
+		}
+	}
+	return lic
+}
+
+type armorCodec struct{}
+
+func (armorCodec) Format() string                           { return FormatArmor }
+func (armorCodec) Encode(lic *LicenseData) ([]byte, error)  { return lic.Armor() }
+func (armorCodec) Decode(data []byte) (*LicenseData, error) { return decodeArmor(data) }
+
+// isTOML reports whether data looks like a TOML license, which has an [info]
+// table
+func isTOML(data []byte) bool {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if bytes.Equal(bytes.TrimSpace(line), []byte("[info]")) {
+			return true
+		}
+	}
+	return false
+}
+
+// isYAML reports whether data looks like a YAML license, starting with a
+// document marker or one of the top level keys
+func isYAML(data []byte) bool {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		if bytes.HasPrefix(line, []byte("---")) {
+			return true
+		}
+		for _, key := range []string{"info:", "key_id:", "key:", "proof:"} {
+			if bytes.HasPrefix(line, []byte(key)) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
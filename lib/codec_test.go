@@ -0,0 +1,112 @@
+package lib_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func TestCodecs(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+
+	nbf := time.Now().Add(-time.Hour)
+	lic := lib.NewLicense("Chathura Colombage", time.Now().Add(24*time.Hour))
+	lic.Info.NotBefore = &nbf
+	lic.Info.Features = []string{"sso", "audit"}
+	lic.Info.Limits = map[string]int{"seats": 25}
+	lic.Info.Metadata = map[string]string{"region": "eu"}
+	if err := lic.Sign(pkey); err != nil {
+		t.Fatal("Failed to sign license:", err)
+	}
+
+	for _, format := range []string{lib.FormatJSON, lib.FormatYAML, lib.FormatTOML, lib.FormatArmor} {
+		codec, err := lib.CodecFor(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := codec.Encode(lic)
+		if err != nil {
+			t.Fatal("Failed to encode", format, "license:", err)
+		}
+		if detected := lib.DetectFormat(data); detected != format {
+			t.Errorf("Expected %s to be detected, but found %s in\n%s", format, detected, data)
+		}
+
+		// convert to every other format and back
+		read, err := codec.Decode(data)
+		if err != nil {
+			t.Fatal("Failed to decode", format, "license:", err)
+		}
+		for _, other := range []lib.Codec{lib.JSONCodec, lib.YAMLCodec, lib.TOMLCodec} {
+			converted, err := other.Encode(read)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if read, err = other.Decode(converted); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := lib.CheckLicense(bytes.NewReader(data), strings.NewReader(pubKey)); err != nil {
+			t.Error("Expected nil error for", format, "license, but found", err)
+		}
+		if err := read.ValidateLicenseKeyWithPublicKey(&pkey.PublicKey); err != nil {
+			t.Error("Expected converted", format, "license to verify, but found", err)
+		}
+	}
+
+	if _, err := lib.CodecFor(lib.FormatJWT); err == nil {
+		t.Error("Expected an error for a format without a codec")
+	}
+}
+
+func TestCodecsProofHashes(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+
+	tl, err := lib.OpenTransparencyLog(filepath.Join(t.TempDir(), "transparency.log"))
+	if err != nil {
+		t.Fatal("Failed to open transparency log:", err)
+	}
+
+	var lic *lib.LicenseData
+	for i := 0; i < 3; i++ {
+		lic = lib.NewLicense("Chathura Colombage", time.Now().Add(time.Hour))
+		if err := lic.Sign(pkey); err != nil {
+			t.Fatal("Failed to sign license:", err)
+		}
+		if err := tl.Publish(lic, pkey); err != nil {
+			t.Fatal("Failed to publish license:", err)
+		}
+	}
+
+	rootHash := base64.StdEncoding.EncodeToString(lic.Proof.TreeHead.Head.RootHash)
+	for _, codec := range []lib.Codec{lib.YAMLCodec, lib.TOMLCodec} {
+		data, err := codec.Encode(lic)
+		if err != nil {
+			t.Fatal("Failed to encode", codec.Format(), "license:", err)
+		}
+		if !bytes.Contains(data, []byte(rootHash)) {
+			t.Errorf("Expected the %s root hash as base64 %s, but found\n%s", codec.Format(), rootHash, data)
+		}
+
+		read, err := codec.Decode(data)
+		if err != nil {
+			t.Fatal("Failed to decode", codec.Format(), "license:", err)
+		}
+		if err := read.VerifyInclusion(&pkey.PublicKey); err != nil {
+			t.Error("Expected", codec.Format(), "license inclusion to verify, but found", err)
+		}
+	}
+}
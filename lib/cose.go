@@ -0,0 +1,384 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// COSE errors
+var (
+	ErrInvalidCOSE   = errors.New("Invalid COSE license")
+	ErrCOSEAlgorithm = errors.New("COSE license algorithm not allowed")
+	ErrCOSEKeyType   = errors.New("COSE licenses are verified with an Ed25519 key")
+)
+
+// COSE licenses are a tagged COSE_Sign1 message (RFC 9052) signed with
+// Ed25519, whose payload is a CBOR map of CWT claims (RFC 8392). They are
+// meant for devices where JSON and RSA are too expensive: verification is a
+// few byte comparisons and one Ed25519 signature check.
+//
+// The licensee, issuer, expiry, not-before date, issue time and ID are the
+// sub, iss, exp, nbf, iat and cti claims. Product, features, limits, metadata
+// and predecessor are claims with text keys of the same name.
+const (
+	coseSign1Tag   = 18
+	coseHeaderAlg  = 1
+	coseHeaderKid  = 4
+	coseSigContext = "Signature1"
+
+	cwtIss = 1
+	cwtSub = 2
+	cwtExp = 4
+	cwtNbf = 5
+	cwtIat = 6
+	cwtCti = 7
+)
+
+// coseProtected is the only protected header accepted, {alg: EdDSA}. Fixing it
+// rules out algorithm substitution without decoding the header.
+var coseProtected = []byte{0xa1, coseHeaderAlg, 0x27}
+
+// Ed25519KeyID returns the key ID of an Ed25519 public key, derived like
+// KeyID from the SHA-256 hash of its PKIX encoding
+func Ed25519KeyID(pub ed25519.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// COSE encodes the license as a COSE_Sign1 message signed with key
+func (lic *LicenseData) COSE(key ed25519.PrivateKey) ([]byte, error) {
+	keyID, err := Ed25519KeyID(key.Public().(ed25519.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+	kid, _ := hex.DecodeString(keyID)
+
+	payload := encodeCOSEClaims(&lic.Info, time.Now())
+	sig := ed25519.Sign(key, coseSigStructure(payload))
+
+	w := &cborWriter{buf: make([]byte, 0, len(payload)+len(sig)+32)}
+	w.head(cborTag, coseSign1Tag)
+	w.head(cborArray, 4)
+	w.bytes(coseProtected)
+	w.head(cborMap, 1)
+	w.int(coseHeaderKid)
+	w.bytes(kid)
+	w.bytes(payload)
+	w.bytes(sig)
+
+	return w.buf, nil
+}
+
+func encodeCOSEClaims(info *LicenseInfo, now time.Time) []byte {
+	w := &cborWriter{}
+
+	n := uint64(3) // sub, exp and iat are always present
+	optional := []bool{
+		info.Issuer != "", info.NotBefore != nil, info.ID != "", info.Product != "",
+		info.Features != nil, info.Limits != nil, info.Metadata != nil, info.Predecessor != "",
+	}
+	for _, ok := range optional {
+		if ok {
+			n++
+		}
+	}
+	w.head(cborMap, n)
+
+	// integer keys sort before text keys in canonical order
+	if info.Issuer != "" {
+		w.int(cwtIss)
+		w.text(info.Issuer)
+	}
+	w.int(cwtSub)
+	w.text(info.Name)
+	w.int(cwtExp)
+	w.int(info.Expiration.Unix())
+	if info.NotBefore != nil {
+		w.int(cwtNbf)
+		w.int(info.NotBefore.Unix())
+	}
+	w.int(cwtIat)
+	w.int(now.Unix())
+	if info.ID != "" {
+		w.int(cwtCti)
+		w.bytes([]byte(info.ID))
+	}
+
+	if info.Limits != nil {
+		w.text("limits")
+		keys := make([]string, 0, len(info.Limits))
+		for k := range info.Limits {
+			keys = append(keys, k)
+		}
+		w.head(cborMap, uint64(len(keys)))
+		for _, k := range sortedKeys(keys) {
+			w.text(k)
+			w.int(int64(info.Limits[k]))
+		}
+	}
+	if info.Product != "" {
+		w.text("product")
+		w.text(info.Product)
+	}
+	if info.Features != nil {
+		w.text("features")
+		w.texts(info.Features)
+	}
+	if info.Metadata != nil {
+		w.text("metadata")
+		keys := make([]string, 0, len(info.Metadata))
+		for k := range info.Metadata {
+			keys = append(keys, k)
+		}
+		w.head(cborMap, uint64(len(keys)))
+		for _, k := range sortedKeys(keys) {
+			w.text(k)
+			w.text(info.Metadata[k])
+		}
+	}
+	if info.Predecessor != "" {
+		w.text("predecessor")
+		w.text(info.Predecessor)
+	}
+
+	return w.buf
+}
+
+// coseSigStructure returns the Sig_structure signed for payload, the array
+// ["Signature1", protected, external_aad, payload]
+func coseSigStructure(payload []byte) []byte {
+	w := &cborWriter{buf: make([]byte, 0, len(payload)+len(coseSigContext)+len(coseProtected)+16)}
+	w.head(cborArray, 4)
+	w.text(coseSigContext)
+	w.bytes(coseProtected)
+	w.bytes(nil)
+	w.bytes(payload)
+	return w.buf
+}
+
+// parseCOSE splits a COSE_Sign1 license into key ID, payload and signature,
+// all sharing data
+func parseCOSE(data []byte) (kid, payload, sig []byte, err error) {
+	r := &cborReader{data: data}
+	if tag, err := r.expect(cborTag); err != nil || tag != coseSign1Tag {
+		return nil, nil, nil, ErrInvalidCOSE
+	}
+	if n, err := r.expect(cborArray); err != nil || n != 4 {
+		return nil, nil, nil, ErrInvalidCOSE
+	}
+
+	protected, err := r.bytes(cborBytes)
+	if err != nil {
+		return nil, nil, nil, ErrInvalidCOSE
+	}
+	if !bytes.Equal(protected, coseProtected) {
+		return nil, nil, nil, ErrCOSEAlgorithm
+	}
+
+	n, err := r.expect(cborMap)
+	if err != nil {
+		return nil, nil, nil, ErrInvalidCOSE
+	}
+	for i := uint64(0); i < n; i++ {
+		label, err := r.int()
+		if err != nil {
+			return nil, nil, nil, ErrInvalidCOSE
+		}
+		switch label {
+		case coseHeaderAlg:
+			// the algorithm must be protected
+			return nil, nil, nil, ErrCOSEAlgorithm
+		case coseHeaderKid:
+			kid, err = r.bytes(cborBytes)
+		default:
+			err = r.skip()
+		}
+		if err != nil {
+			return nil, nil, nil, ErrInvalidCOSE
+		}
+	}
+
+	if payload, err = r.bytes(cborBytes); err != nil {
+		return nil, nil, nil, ErrInvalidCOSE
+	}
+	if sig, err = r.bytes(cborBytes); err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, nil, nil, ErrInvalidCOSE
+	}
+	if r.off != len(data) {
+		return nil, nil, nil, ErrInvalidCOSE
+	}
+
+	return kid, payload, sig, nil
+}
+
+// VerifyCOSE verifies a COSE license with key and returns its CBOR payload,
+// which shares data. Only the signed structure is allocated, so this suits
+// devices that check the signature once and read claims themselves.
+func VerifyCOSE(data []byte, key ed25519.PublicKey) ([]byte, error) {
+	_, payload, sig, err := parseCOSE(data)
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(key, coseSigStructure(payload), sig) {
+		return nil, InvalidLicense
+	}
+	return payload, nil
+}
+
+// VerifyCOSELicense verifies a COSE license with key and returns the license
+// information it holds. Expiry is not checked.
+func VerifyCOSELicense(data []byte, key ed25519.PublicKey) (*LicenseInfo, error) {
+	payload, err := VerifyCOSE(data, key)
+	if err != nil {
+		return nil, err
+	}
+	return decodeCOSEClaims(payload)
+}
+
+func decodeCOSEClaims(payload []byte) (*LicenseInfo, error) {
+	r := &cborReader{data: payload}
+	n, err := r.expect(cborMap)
+	if err != nil {
+		return nil, ErrInvalidCOSE
+	}
+
+	info := &LicenseInfo{}
+	var hasExp bool
+	for i := uint64(0); i < n && err == nil; i++ {
+		if r.off < len(payload) && payload[r.off]>>5 == cborText {
+			var key string
+			if key, err = r.text(); err == nil {
+				err = decodeCOSEPrivateClaim(r, key, info)
+			}
+			continue
+		}
+
+		var label, t int64
+		if label, err = r.int(); err != nil {
+			break
+		}
+		switch label {
+		case cwtIss:
+			info.Issuer, err = r.text()
+		case cwtSub:
+			info.Name, err = r.text()
+		case cwtExp:
+			t, err = r.int()
+			info.Expiration, hasExp = time.Unix(t, 0).UTC(), true
+		case cwtNbf:
+			t, err = r.int()
+			nbf := time.Unix(t, 0).UTC()
+			info.NotBefore = &nbf
+		case cwtCti:
+			var id []byte
+			id, err = r.bytes(cborBytes)
+			info.ID = string(id)
+		default:
+			err = r.skip()
+		}
+	}
+	if err != nil || !hasExp || r.off != len(payload) {
+		return nil, ErrInvalidCOSE
+	}
+
+	return info, nil
+}
+
+func decodeCOSEPrivateClaim(r *cborReader, key string, info *LicenseInfo) error {
+	var err error
+	switch key {
+	case "product":
+		info.Product, err = r.text()
+	case "features":
+		info.Features, err = r.texts()
+	case "predecessor":
+		info.Predecessor, err = r.text()
+	case "limits", "metadata":
+		var n uint64
+		if n, err = r.expect(cborMap); err != nil {
+			return err
+		}
+		if n > uint64(len(r.data)-r.off) {
+			return errCBOR
+		}
+		if key == "limits" {
+			info.Limits = make(map[string]int, n)
+		} else {
+			info.Metadata = make(map[string]string, n)
+		}
+		for i := uint64(0); i < n && err == nil; i++ {
+			var k string
+			if k, err = r.text(); err != nil {
+				break
+			}
+			if key == "limits" {
+				var v int64
+				v, err = r.int()
+				info.Limits[k] = int(v)
+			} else {
+				info.Metadata[k], err = r.text()
+			}
+		}
+	default:
+		err = r.skip()
+	}
+	return err
+}
+
+// decodeCOSE reads a COSE license without verifying it. The message is kept
+// so that ValidateCOSE checks its signature.
+func decodeCOSE(data []byte) (*LicenseData, error) {
+	kid, payload, sig, err := parseCOSE(data)
+	if err != nil {
+		return nil, err
+	}
+	info, err := decodeCOSEClaims(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LicenseData{
+		Info:  *info,
+		KeyID: hex.EncodeToString(kid),
+		Key:   encodeKey(sig),
+		cose:  append([]byte(nil), data...),
+	}, nil
+}
+
+// ValidateCOSE checks the COSE message the license was read from with key,
+// and that the license information still matches its claims
+func (lic *LicenseData) ValidateCOSE(key ed25519.PublicKey) error {
+	if lic.cose == nil {
+		return ErrInvalidCOSE
+	}
+
+	signed, err := VerifyCOSELicense(lic.cose, key)
+	if err != nil {
+		return err
+	}
+
+	signedJSON, err := json.Marshal(signed)
+	if err != nil {
+		return err
+	}
+	current, err := lic.CanonicalPayload()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(signedJSON, current) {
+		return ErrInvalidCOSE
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package lib_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func TestCOSELicense(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nbf := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	lic := lib.NewLicense("Chathura Colombage", time.Now().Add(24*time.Hour))
+	lic.Info.Product = "firmware"
+	lic.Info.NotBefore = &nbf
+	lic.Info.Features = []string{"ota", "telemetry"}
+	lic.Info.Limits = map[string]int{"sensors": 8, "rate": -1}
+	lic.Info.Metadata = map[string]string{"board": "rev-c"}
+
+	data, err := lic.COSE(priv)
+	if err != nil {
+		t.Fatal("Failed to encode COSE license:", err)
+	}
+	if lib.DetectFormat(data) != lib.FormatCOSE {
+		t.Error("Expected COSE format to be detected")
+	}
+
+	info, err := lib.VerifyCOSELicense(data, pub)
+	if err != nil {
+		t.Fatal("Failed to verify COSE license:", err)
+	}
+	if info.Name != lic.Info.Name || info.ID != lic.Info.ID || info.Product != "firmware" ||
+		info.Expiration.Unix() != lic.Info.Expiration.Unix() || !info.NotBefore.Equal(nbf) ||
+		!info.HasFeature("telemetry") || info.Limits["rate"] != -1 || info.Metadata["board"] != "rev-c" {
+		t.Error("Expected", lic.Info, "but found", info)
+	}
+
+	read, err := lib.ReadLicense(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("Failed to read COSE license:", err)
+	}
+	if err := read.ValidateCOSE(pub); err != nil {
+		t.Error("Expected nil error, but found", err)
+	}
+	if read.Algorithm() != lib.AlgEdDSA {
+		t.Error("Expected EdDSA, but found", read.Algorithm())
+	}
+	if err := lib.CheckLicense(bytes.NewReader(data), strings.NewReader(pubKey)); err == nil {
+		t.Error("Expected an error verifying a COSE license with an RSA key")
+	}
+
+	read.Info.Name = "Someone"
+	if err := read.ValidateCOSE(pub); err == nil {
+		t.Error("Expected an error for license information changed after reading")
+	}
+
+	tampered := bytes.Replace(data, []byte("Chathura"), []byte("Chathurb"), 1)
+	if _, err := lib.VerifyCOSELicense(tampered, pub); err != lib.InvalidLicense {
+		t.Error("Expected InvalidLicense, but found", err)
+	}
+
+	// the protected header is {1: -8}; any other algorithm is rejected
+	otherAlg := bytes.Replace(data, []byte{0x43, 0xa1, 0x01, 0x27}, []byte{0x43, 0xa1, 0x01, 0x26}, 1)
+	if _, err := lib.VerifyCOSELicense(otherAlg, pub); err != lib.ErrCOSEAlgorithm {
+		t.Error("Expected ErrCOSEAlgorithm, but found", err)
+	}
+
+	for i := range data {
+		if _, err := lib.VerifyCOSELicense(data[:i], pub); err == nil {
+			t.Fatal("Expected an error for a license truncated to", i, "bytes")
+		}
+	}
+
+	allocs := testing.AllocsPerRun(10, func() {
+		if _, err := lib.VerifyCOSE(data, pub); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs > 1 {
+		t.Error("Expected at most 1 allocation verifying a COSE license, but found", allocs)
+	}
+}
+
+func TestVerifierCOSE(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+
+	lic := lib.NewLicense("Chathura Colombage", time.Now().Add(24*time.Hour))
+	data, err := lic.COSE(priv)
+	if err != nil {
+		t.Fatal("Failed to encode COSE license:", err)
+	}
+
+	ctx := context.Background()
+	if _, err := lib.NewVerifier(lib.WithPublicKey(&pkey.PublicKey)).Verify(ctx, data); !errors.Is(err, lib.InvalidLicense) {
+		t.Error("Expected a COSE license to fail with only an RSA key, but found", err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := lib.NewTrustStore(&pkey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.AddPEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})); err != nil {
+		t.Fatal("Failed to add Ed25519 key:", err)
+	}
+
+	for _, v := range []*lib.Verifier{
+		lib.NewVerifier(lib.WithEd25519Key(pub)),
+		lib.NewVerifier(lib.WithTrustStore(ts)),
+	} {
+		vl, err := v.Verify(ctx, data)
+		if err != nil {
+			t.Fatal("Expected COSE license to verify, but found", err)
+		}
+		if keyID, _ := lib.Ed25519KeyID(pub); vl.KeyID != keyID {
+			t.Errorf("Expected key ID %s, but found %s", keyID, vl.KeyID)
+		}
+	}
+
+	// revocation lists stay signed with the RSA key of the product
+	rl := lib.NewRevocationList()
+	rl.Revoke(lib.Revocation{LicenseID: lic.Info.ID})
+	if err := rl.Sign(pkey); err != nil {
+		t.Fatal("Failed to sign revocation list:", err)
+	}
+	v := lib.NewVerifier(lib.WithTrustStore(ts), lib.WithRevocationList(rl))
+	if _, err := v.Verify(ctx, data); !errors.Is(err, lib.ErrLicenseRevoked) {
+		t.Error("Expected ErrLicenseRevoked, but found", err)
+	}
+	v = lib.NewVerifier(lib.WithEd25519Key(pub), lib.WithRevocationList(rl))
+	if _, err := v.Verify(ctx, data); !errors.Is(err, lib.ErrUntrustedKey) {
+		t.Error("Expected ErrUntrustedKey for a list signed by an untrusted key, but found", err)
+	}
+}
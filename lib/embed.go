@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidEmbeddedKey is returned for an embedded public key that is not a
+// PEM encoded RSA or Ed25519 public key
+var ErrInvalidEmbeddedKey = errors.New("Invalid embedded public key")
+
+// EmbeddedPolicy - Trusted keys and license policy compiled into an
+// application, usually by a package generated with lgen embed. Unlike a
+// cert.pem shipped next to the binary, users cannot swap the keys for their
+// own.
+type EmbeddedPolicy struct {
+	// PublicKeys are the PEM encoded public keys licenses may be signed with,
+	// RSA keys for licenses and Ed25519 keys for COSE licenses
+	PublicKeys []string
+	// Product is the product licenses must be for, any when empty
+	Product string
+	// GracePeriod is how long an expired license is still accepted
+	GracePeriod time.Duration
+	// RevocationList is the file or URL of the revocation list, none when empty
+	RevocationList string
+}
+
+// NewEmbeddedVerifier returns a Verifier enforcing the policy. opts are applied
+// after the policy.
+func NewEmbeddedVerifier(policy EmbeddedPolicy, opts ...VerifyOption) (*Verifier, error) {
+	if len(policy.PublicKeys) == 0 {
+		return nil, ErrNoTrustedKeys
+	}
+
+	ts, err := NewTrustStore()
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range policy.PublicKeys {
+		if err := ts.AddPEM([]byte(key)); err != nil {
+			return nil, fmt.Errorf("%w %d: %s", ErrInvalidEmbeddedKey, i, err)
+		}
+	}
+
+	policyOpts := []VerifyOption{WithTrustStore(ts)}
+	if policy.Product != "" {
+		policyOpts = append(policyOpts, WithRequiredProduct(policy.Product))
+	}
+	if policy.GracePeriod > 0 {
+		policyOpts = append(policyOpts, WithGracePeriod(policy.GracePeriod))
+	}
+	if policy.RevocationList != "" {
+		policyOpts = append(policyOpts, WithRevocationListFrom(policy.RevocationList))
+	}
+
+	return NewVerifier(append(policyOpts, opts...)...), nil
+}
@@ -0,0 +1,56 @@
+package lib_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func TestEmbeddedVerifier(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+
+	policy := lib.EmbeddedPolicy{
+		PublicKeys:  []string{pubKey},
+		Product:     "app",
+		GracePeriod: 7 * 24 * time.Hour,
+	}
+	verifier, err := lib.NewEmbeddedVerifier(policy)
+	if err != nil {
+		t.Fatal("Failed to build verifier:", err)
+	}
+
+	lic := lib.NewLicense("Chathura Colombage", time.Now().Add(-24*time.Hour))
+	lic.Info.Product = "app"
+	if err := lic.Sign(pkey); err != nil {
+		t.Fatal("Failed to sign license:", err)
+	}
+	vl, err := verifier.VerifyLicense(context.Background(), lic)
+	if err != nil {
+		t.Fatal("Expected license in its grace period to verify, but found", err)
+	}
+	if vl.Status.Status != lib.StatusGrace {
+		t.Error("Expected grace status, but found", vl.Status.Status)
+	}
+
+	lic.Info.Product = "other"
+	if err := lic.Sign(pkey); err != nil {
+		t.Fatal("Failed to sign license:", err)
+	}
+	if _, err := verifier.VerifyLicense(context.Background(), lic); !errors.Is(err, lib.ErrWrongProduct) {
+		t.Error("Expected ErrWrongProduct, but found", err)
+	}
+
+	if _, err := lib.NewEmbeddedVerifier(lib.EmbeddedPolicy{PublicKeys: []string{"not a key"}}); !errors.Is(err, lib.ErrInvalidEmbeddedKey) {
+		t.Error("Expected ErrInvalidEmbeddedKey, but found", err)
+	}
+	if _, err := lib.NewEmbeddedVerifier(lib.EmbeddedPolicy{}); err != lib.ErrNoTrustedKeys {
+		t.Error("Expected ErrNoTrustedKeys, but found", err)
+	}
+}
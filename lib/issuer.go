@@ -0,0 +1,166 @@
+package lib
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Issuer errors
+var (
+	ErrNoLicensee    = errors.New("License needs a licensee name")
+	ErrNoExpiration  = errors.New("License needs an expiration or a validity")
+	ErrSignerKeyType = errors.New("Signer must use an RSA key")
+)
+
+// IssuerOption configures the defaults of an Issuer
+type IssuerOption func(*Issuer)
+
+// WithDefaultProduct sets the product of licenses issued without one
+func WithDefaultProduct(product string) IssuerOption {
+	return func(is *Issuer) {
+		is.product = product
+	}
+}
+
+// WithIssuerName records name as the issuer of every license
+func WithIssuerName(name string) IssuerOption {
+	return func(is *Issuer) {
+		is.name = name
+	}
+}
+
+// WithDefaultValidity sets how long licenses issued without an expiration are
+// valid for
+func WithDefaultValidity(validity time.Duration) IssuerOption {
+	return func(is *Issuer) {
+		is.validity = validity
+	}
+}
+
+// WithIDGenerator replaces NewLicenseID for licenses issued without an ID
+func WithIDGenerator(newID func() string) IssuerOption {
+	return func(is *Issuer) {
+		is.newID = newID
+	}
+}
+
+// WithIssuerClock sets the time source validity is counted from
+func WithIssuerClock(now func() time.Time) IssuerOption {
+	return func(is *Issuer) {
+		is.now = now
+	}
+}
+
+// LicenseSpec - What to issue. Fields left empty are filled in from the
+// Issuer defaults.
+type LicenseSpec struct {
+	LicenseInfo
+
+	// Validity is used instead of the issuer default when Expiration is zero
+	Validity time.Duration
+}
+
+// Issuer signs licenses with a signing backend loaded once. It is safe for
+// concurrent use.
+type Issuer struct {
+	signer crypto.Signer
+	keyID  string
+
+	product  string
+	name     string
+	validity time.Duration
+	newID    func() string
+	now      func() time.Time
+}
+
+// NewIssuer returns an Issuer signing with signer, which must hold an RSA key.
+// An *rsa.PrivateKey works, as do signers backed by a KMS or HSM.
+func NewIssuer(signer crypto.Signer, opts ...IssuerOption) (*Issuer, error) {
+	pub, ok := signer.Public().(*rsa.PublicKey)
+	if !ok {
+		return nil, ErrSignerKeyType
+	}
+
+	keyID, err := KeyID(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	is := &Issuer{signer: signer, keyID: keyID, newID: NewLicenseID, now: time.Now}
+	for _, opt := range opts {
+		opt(is)
+	}
+	return is, nil
+}
+
+// LoadIssuer returns an Issuer signing with the private key read from a file
+func LoadIssuer(privKey string, opts ...IssuerOption) (*Issuer, error) {
+	pkey, err := ReadPrivateKeyFromFile(privKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewIssuer(pkey, opts...)
+}
+
+// KeyID returns the ID of the key licenses are signed with
+func (is *Issuer) KeyID() string {
+	return is.keyID
+}
+
+// PublicKey returns the key licenses are verified with
+func (is *Issuer) PublicKey() *rsa.PublicKey {
+	return is.signer.Public().(*rsa.PublicKey)
+}
+
+// IssueLicense fills in the defaults of spec and returns the signed license
+func (is *Issuer) IssueLicense(ctx context.Context, spec LicenseSpec) (*LicenseData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	info := spec.LicenseInfo
+	if info.Name == "" {
+		return nil, ErrNoLicensee
+	}
+	if info.ID == "" {
+		info.ID = is.newID()
+	}
+	if info.Product == "" {
+		info.Product = is.product
+	}
+	if info.Issuer == "" {
+		info.Issuer = is.name
+	}
+	if info.Expiration.IsZero() {
+		validity := spec.Validity
+		if validity == 0 {
+			validity = is.validity
+		}
+		if validity <= 0 {
+			return nil, ErrNoExpiration
+		}
+		info.Expiration = is.now().Add(validity).UTC().Truncate(time.Second)
+	}
+
+	lic := &LicenseData{Info: info}
+	if err := lic.SignWithSigner(is.signer); err != nil {
+		return nil, err
+	}
+
+	return lic, nil
+}
+
+// Issue returns a signed license serialized as a license file
+func (is *Issuer) Issue(ctx context.Context, spec LicenseSpec) ([]byte, error) {
+	lic, err := is.IssueLicense(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(lic, "", "  ")
+}
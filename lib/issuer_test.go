@@ -0,0 +1,69 @@
+package lib_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func TestIssuer(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	issuer, err := lib.NewIssuer(pkey, lib.WithDefaultProduct("app"), lib.WithIssuerName("ops"),
+		lib.WithDefaultValidity(30*24*time.Hour), lib.WithIssuerClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatal("Failed to create issuer:", err)
+	}
+
+	verifier := lib.NewVerifier(lib.WithPublicKey(issuer.PublicKey()), lib.WithRequiredProduct("app"),
+		lib.WithClock(func() time.Time { return now }))
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	ids := make([]string, 8)
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data, err := issuer.Issue(ctx, lib.LicenseSpec{LicenseInfo: lib.LicenseInfo{Name: "Chathura Colombage"}})
+			if err != nil {
+				t.Error("Failed to issue license:", err)
+				return
+			}
+			vl, err := verifier.Verify(ctx, data)
+			if err != nil {
+				t.Error("Failed to verify issued license:", err)
+				return
+			}
+			ids[i] = vl.ID
+		}(i)
+	}
+	wg.Wait()
+
+	if ids[0] == "" || ids[0] == ids[1] {
+		t.Error("Expected unique license IDs, but found", ids)
+	}
+
+	lic, err := issuer.IssueLicense(ctx, lib.LicenseSpec{LicenseInfo: lib.LicenseInfo{Name: "ACME"}, Validity: time.Hour})
+	if err != nil {
+		t.Fatal("Failed to issue license:", err)
+	}
+	if lic.Info.Issuer != "ops" || lic.Info.Product != "app" || !lic.Info.Expiration.Equal(now.Add(time.Hour)) {
+		t.Error("Issuer defaults not applied:", lic.Info)
+	}
+	if lic.KeyID != issuer.KeyID() {
+		t.Error("Expected key ID", issuer.KeyID(), "but found", lic.KeyID)
+	}
+
+	if _, err := issuer.Issue(ctx, lib.LicenseSpec{}); err != lib.ErrNoLicensee {
+		t.Error("Expected ErrNoLicensee, but found", err)
+	}
+}
@@ -0,0 +1,216 @@
+package lib
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// JWT errors
+var (
+	ErrInvalidJWT   = errors.New("Invalid JWT license")
+	ErrJWTAlgorithm = errors.New("JWT license algorithm not allowed")
+)
+
+// jwtHeader - The JOSE header of a JWT license
+type jwtHeader struct {
+	Alg  string   `json:"alg"`
+	Typ  string   `json:"typ,omitempty"`
+	Kid  string   `json:"kid,omitempty"`
+	Crit []string `json:"crit,omitempty"`
+}
+
+// licenseClaims - The claims of a JWT license. Registered claims carry the
+// LicenseInfo fields they correspond to, the rest are private claims.
+type licenseClaims struct {
+	Subject   string `json:"sub"`
+	Issuer    string `json:"iss,omitempty"`
+	ID        string `json:"jti,omitempty"`
+	Expires   int64  `json:"exp"`
+	NotBefore *int64 `json:"nbf,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+
+	Product     string            `json:"product,omitempty"`
+	Features    []string          `json:"features,omitempty"`
+	Limits      map[string]int    `json:"limits,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Predecessor string            `json:"predecessor,omitempty"`
+}
+
+func (c *licenseClaims) info() LicenseInfo {
+	info := LicenseInfo{
+		ID:          c.ID,
+		Product:     c.Product,
+		Issuer:      c.Issuer,
+		Name:        c.Subject,
+		Expiration:  time.Unix(c.Expires, 0).UTC(),
+		Features:    c.Features,
+		Limits:      c.Limits,
+		Metadata:    c.Metadata,
+		Predecessor: c.Predecessor,
+	}
+	if c.NotBefore != nil {
+		nbf := time.Unix(*c.NotBefore, 0).UTC()
+		info.NotBefore = &nbf
+	}
+	return info
+}
+
+var b64url = base64.RawURLEncoding
+
+// JWT encodes the license as a JWS compact token signed with RS256, for
+// services that verify licenses with a JWT library. Times are rounded down to
+// whole seconds, which changes the license information, so a license read back
+// from a JWT carries only the JWT signature.
+func (lic *LicenseData) JWT(signer crypto.Signer) (string, error) {
+	pub, ok := signer.Public().(*rsa.PublicKey)
+	if !ok {
+		return "", ErrSignerKeyType
+	}
+	keyID, err := KeyID(pub)
+	if err != nil {
+		return "", err
+	}
+
+	claims := licenseClaims{
+		Subject:     lic.Info.Name,
+		Issuer:      lic.Info.Issuer,
+		ID:          lic.Info.ID,
+		Expires:     lic.Info.Expiration.Unix(),
+		IssuedAt:    time.Now().Unix(),
+		Product:     lic.Info.Product,
+		Features:    lic.Info.Features,
+		Limits:      lic.Info.Limits,
+		Metadata:    lic.Info.Metadata,
+		Predecessor: lic.Info.Predecessor,
+	}
+	if lic.Info.NotBefore != nil {
+		nbf := lic.Info.NotBefore.Unix()
+		claims.NotBefore = &nbf
+	}
+
+	header, err := json.Marshal(jwtHeader{Alg: AlgRS256, Typ: "JWT", Kid: keyID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := b64url.EncodeToString(header) + "." + b64url.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + b64url.EncodeToString(sig), nil
+}
+
+// isJWT reports whether data looks like a JWS compact token with a JSON header
+func isJWT(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return bytes.HasPrefix(data, []byte("eyJ")) && bytes.Count(data, []byte(".")) == 2 &&
+		bytes.IndexAny(data, " \t\r\n") < 0
+}
+
+// splitJWT decodes the parts of a JWS compact token. Only RS256 is accepted:
+// "none" would skip verification and HMAC algorithms would let the public key
+// be used as a shared secret.
+func splitJWT(token string) (*jwtHeader, *licenseClaims, []byte, error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return nil, nil, nil, ErrInvalidJWT
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, nil, nil, err
+	}
+	if header.Alg != AlgRS256 {
+		return nil, nil, nil, ErrJWTAlgorithm
+	}
+	if len(header.Crit) > 0 {
+		// no extensions are understood, so none can be critical
+		return nil, nil, nil, ErrInvalidJWT
+	}
+
+	var claims licenseClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, nil, nil, err
+	}
+	if claims.Expires == 0 {
+		return nil, nil, nil, ErrInvalidJWT
+	}
+
+	sig, err := b64url.DecodeString(parts[2])
+	if err != nil || len(sig) == 0 {
+		return nil, nil, nil, ErrInvalidJWT
+	}
+
+	return &header, &claims, sig, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := b64url.DecodeString(part)
+	if err != nil {
+		return ErrInvalidJWT
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return ErrInvalidJWT
+	}
+	return nil
+}
+
+// decodeJWT reads a JWT license without verifying it. The token is kept so
+// that ValidateLicenseKeyWithPublicKey checks its signature.
+func decodeJWT(data []byte) (*LicenseData, error) {
+	token := strings.TrimSpace(string(data))
+	header, claims, sig, err := splitJWT(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LicenseData{
+		Info:  claims.info(),
+		KeyID: header.Kid,
+		Key:   encodeKey(sig),
+		jwt:   token,
+	}, nil
+}
+
+// verifyJWT checks the JWT the license was read from with publicKey, and
+// that the license information still matches its claims
+func (lic *LicenseData) verifyJWT(publicKey *rsa.PublicKey) error {
+	_, claims, sig, err := splitJWT(lic.jwt)
+	if err != nil {
+		return err
+	}
+
+	signingInput := lic.jwt[:strings.LastIndex(lic.jwt, ".")]
+	if err := Unsign(publicKey, []byte(signingInput), sig); err != nil {
+		return err
+	}
+
+	signed, err := json.Marshal(claims.info())
+	if err != nil {
+		return err
+	}
+	current, err := lic.CanonicalPayload()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(signed, current) {
+		return ErrInvalidJWT
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package lib_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func TestJWTLicense(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+
+	lic := lib.NewLicense("Chathura Colombage", time.Now().Add(24*time.Hour))
+	lic.Info.Product = "acme"
+	lic.Info.Features = []string{"sso"}
+	token, err := lic.JWT(pkey)
+	if err != nil {
+		t.Fatal("Failed to encode JWT:", err)
+	}
+	if lib.DetectFormat([]byte(token+"\n")) != lib.FormatJWT {
+		t.Error("Expected JWT format to be detected")
+	}
+
+	read, err := lib.ReadLicense(strings.NewReader(token))
+	if err != nil {
+		t.Fatal("Failed to read JWT license:", err)
+	}
+	if read.Info.Name != lic.Info.Name || read.Info.ID != lic.Info.ID || !read.Info.HasFeature("sso") ||
+		read.Info.Expiration.Unix() != lic.Info.Expiration.Unix() {
+		t.Error("Expected", lic.Info, "but found", read.Info)
+	}
+
+	vl, err := lib.NewVerifier(lib.WithPublicKey(&pkey.PublicKey), lib.WithRequiredProduct("acme")).
+		Verify(context.Background(), []byte(token))
+	if err != nil {
+		t.Fatal("Expected nil error, but found", err)
+	}
+	if vl.KeyID == "" {
+		t.Error("Expected the key ID of the signing key, but found", vl.KeyID)
+	}
+
+	read.Info.Features = append(read.Info.Features, "audit")
+	if err := read.ValidateLicenseKeyWithPublicKey(&pkey.PublicKey); err == nil {
+		t.Error("Expected an error for license information changed after reading")
+	}
+
+	parts := strings.Split(token, ".")
+	claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	forged := strings.Replace(string(claims), `"sub":"Chathura Colombage"`, `"sub":"Someone"`, 1)
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(forged)) + "." + parts[2]
+	if err := lib.CheckLicense(strings.NewReader(tampered), strings.NewReader(pubKey)); err == nil {
+		t.Error("Expected an error for tampered claims")
+	}
+}
+
+func TestJWTLicenseRejectsAlgorithms(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+
+	lic := lib.NewLicense("Chathura Colombage", time.Now().Add(24*time.Hour))
+	token, err := lic.JWT(pkey)
+	if err != nil {
+		t.Fatal("Failed to encode JWT:", err)
+	}
+	claims := strings.Split(token, ".")[1]
+
+	encode := func(header string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + claims
+	}
+
+	// alg none with an empty signature
+	none := encode(`{"alg":"none","typ":"JWT"}`) + "."
+	// HS256 keyed with the public key, which verifiers using the key for any
+	// algorithm would accept
+	confused := encode(`{"alg":"HS256","typ":"JWT"}`)
+	mac := hmac.New(sha256.New, []byte(pubKey))
+	mac.Write([]byte(confused))
+	confused += "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	for _, tok := range []string{none, confused} {
+		if _, err := lib.ReadLicense(strings.NewReader(tok)); err != lib.ErrJWTAlgorithm {
+			t.Error("Expected ErrJWTAlgorithm, but found", err)
+		}
+		if err := lib.CheckLicense(strings.NewReader(tok), strings.NewReader(pubKey)); err == nil {
+			t.Error("Expected an error for", tok)
+		}
+	}
+}
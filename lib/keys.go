@@ -5,8 +5,6 @@ package lib
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// +build ignore
-
 // Generate a self-signed X.509 certificate for a TLS server. Outputs to
 // 'cert.pem' and 'key.pem' and will overwrite existing files.
 
@@ -15,8 +13,10 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/hex"
 	"encoding/pem"
 	"fmt"
 	"io"
@@ -177,11 +177,12 @@ func GenerateCertificate(certName string, keyName string, rsaBits int) error {
 		Type:  "RSA PRIVATE KEY",
 		Bytes: privBytes,
 	})
-	if err := ioutil.WriteFile(keyName, privBytes, 0644); err != nil {
+	if err := ioutil.WriteFile(keyName, privBytes, 0600); err != nil {
 		return err
 	}
 
-	return nil
+	// WriteFile keeps the mode of a key file that is being overwritten
+	return os.Chmod(keyName, 0600)
 }
 
 func ReadPublicKey(r io.Reader) (*rsa.PublicKey, error) {
@@ -240,3 +241,35 @@ func ReadPrivateKeyFromFile(key string) (*rsa.PrivateKey, error) {
 
 	return ReadPrivateKey(file)
 }
+
+// KeyID returns a short identifier for a public key, derived from the SHA-256
+// hash of its PKIX encoding
+func KeyID(pub *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// Fingerprint returns the SHA-256 hash of the PKIX encoding of a public key
+// as colon separated hex. KeyID is its first 8 bytes.
+func Fingerprint(pub *rsa.PublicKey) (string, error) {
+	return pkixFingerprint(pub)
+}
+
+func pkixFingerprint(pub interface{}) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = hex.EncodeToString([]byte{b})
+	}
+	return strings.Join(parts, ":"), nil
+}
@@ -0,0 +1,214 @@
+// Package licensegrpc enforces licenses in gRPC servers. Server interceptors
+// reject calls while the license is invalid or lacks the feature a method
+// needs, and a client interceptor attaches a license token to outgoing calls.
+package licensegrpc
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+// ErrorDomain is the domain of the ErrorInfo detail of rejected calls
+const ErrorDomain = "license_gen"
+
+// ErrorInfo reasons of rejected calls
+const (
+	ReasonLicenseInvalid = "LICENSE_INVALID"
+	ReasonFeatureMissing = "FEATURE_NOT_LICENSED"
+)
+
+// TokenMetadataKey is the metadata key license tokens are sent in
+const TokenMetadataKey = "license-token"
+
+// Source provides the current license. *lib.Watcher is a Source that keeps
+// the license up to date.
+type Source interface {
+	// License returns the valid license, or nil when there is none
+	License() *lib.VerifiedLicense
+	// Err returns why there is no valid license
+	Err() error
+}
+
+// Enforcer checks calls against the license of a Source
+type Enforcer struct {
+	source   Source
+	features map[string]string
+}
+
+// New returns an Enforcer for the license provided by source. features maps
+// full method names ("/pkg.Service/Method") or service prefixes
+// ("/pkg.Service/") to the feature they need. Other methods only need a valid
+// license.
+func New(source Source, features map[string]string) *Enforcer {
+	fs := make(map[string]string, len(features))
+	for k, v := range features {
+		fs[k] = v
+	}
+	return &Enforcer{source: source, features: fs}
+}
+
+// feature returns the feature method needs, preferring an exact match over
+// the service prefix
+func (e *Enforcer) feature(method string) string {
+	if f, ok := e.features[method]; ok {
+		return f
+	}
+	if i := strings.LastIndex(method, "/"); i > 0 {
+		return e.features[method[:i+1]]
+	}
+	return ""
+}
+
+// Check returns a PermissionDenied status error when method may not be
+// called under the current license
+func (e *Enforcer) Check(method string) error {
+	license := e.source.License()
+	if license == nil {
+		msg := "No valid license"
+		md := map[string]string{"method": method}
+		if err := e.source.Err(); err != nil {
+			msg = err.Error()
+			var verr *lib.ValidationError
+			if errors.As(err, &verr) {
+				md["code"] = verr.Code
+			}
+		}
+		return permissionDenied(msg, ReasonLicenseInvalid, md)
+	}
+
+	if feature := e.feature(method); feature != "" && !license.HasFeature(feature) {
+		return permissionDenied("The license does not include the "+feature+" feature",
+			ReasonFeatureMissing, map[string]string{"method": method, "feature": feature})
+	}
+
+	return nil
+}
+
+func permissionDenied(msg, reason string, md map[string]string) error {
+	st := status.New(codes.PermissionDenied, msg)
+	if detailed, err := st.WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: ErrorDomain, Metadata: md}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// UnaryServerInterceptor enforces the license on unary calls
+func (e *Enforcer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := e.Check(info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor enforces the license when a stream is opened
+func (e *Enforcer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := e.Check(info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// TokenSource provides license tokens for outgoing calls. *lib.TokenClient is
+// a TokenSource.
+type TokenSource interface {
+	Token() (*lib.Token, error)
+}
+
+// EncodeToken encodes tok for the license-token metadata
+func EncodeToken(tok *lib.Token) (string, error) {
+	data, err := json.Marshal(tok)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeToken decodes a token encoded with EncodeToken
+func DecodeToken(s string) (*lib.Token, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, lib.ErrInvalidToken
+	}
+
+	var tok lib.Token
+	if err := json.Unmarshal(data, &tok); err != nil {
+		return nil, lib.ErrInvalidToken
+	}
+	return &tok, nil
+}
+
+func withToken(ctx context.Context, ts TokenSource) (context.Context, error) {
+	tok, err := ts.Token()
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	encoded, err := EncodeToken(tok)
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, TokenMetadataKey, encoded), nil
+}
+
+// UnaryClientInterceptor attaches a license token from ts to unary calls
+func UnaryClientInterceptor(ts TokenSource) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, err := withToken(ctx, ts)
+		if err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor attaches a license token from ts to streams
+func StreamClientInterceptor(ts TokenSource) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, err := withToken(ctx, ts)
+		if err != nil {
+			return nil, err
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+// TokenFromIncomingContext returns the license token sent with a call after
+// verifying it with publicKey
+func TokenFromIncomingContext(ctx context.Context, publicKey *rsa.PublicKey) (*lib.Token, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(TokenMetadataKey)
+	if len(values) == 0 {
+		return nil, lib.ErrNoToken
+	}
+
+	tok, err := DecodeToken(values[0])
+	if err != nil {
+		return nil, err
+	}
+	if err := tok.Verify(publicKey); err != nil {
+		return nil, err
+	}
+	if err := tok.CheckExpiry(time.Now()); err != nil {
+		return nil, err
+	}
+
+	return tok, nil
+}
@@ -0,0 +1,118 @@
+package licensegrpc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/dewaka/license_gen/lib"
+	"github.com/dewaka/license_gen/lib/licensegrpc"
+)
+
+type testSource struct {
+	license *lib.VerifiedLicense
+	err     error
+}
+
+func (s *testSource) License() *lib.VerifiedLicense { return s.license }
+func (s *testSource) Err() error                    { return s.err }
+
+type testTokens struct {
+	token *lib.Token
+}
+
+func (s *testTokens) Token() (*lib.Token, error) { return s.token, nil }
+
+func TestEnforcer(t *testing.T) {
+	pkey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lic := lib.NewLicense("ACME", time.Now().Add(24*time.Hour))
+	lic.Info.Features = []string{"reports"}
+	if err := lic.Sign(pkey); err != nil {
+		t.Fatal(err)
+	}
+	vl, err := lib.NewVerifier(lib.WithPublicKey(&pkey.PublicKey)).VerifyLicense(context.Background(), lic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := &testSource{license: vl}
+	enforcer := licensegrpc.New(source, map[string]string{
+		"/acme.Reports/":       "reports",
+		"/acme.Admin/SSOLogin": "sso",
+	})
+
+	interceptor := enforcer.UnaryServerInterceptor()
+	call := func(method string) error {
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+		_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	if err := call("/acme.Reports/Daily"); err != nil {
+		t.Error("Expected nil error, but found", err)
+	}
+	if err := call("/acme.Admin/List"); err != nil {
+		t.Error("Expected nil error, but found", err)
+	}
+
+	st := status.Convert(call("/acme.Admin/SSOLogin"))
+	if st.Code() != codes.PermissionDenied || len(st.Details()) != 1 {
+		t.Fatal("Expected PermissionDenied with details, but found", st)
+	}
+	info, ok := st.Details()[0].(*errdetails.ErrorInfo)
+	if !ok || info.Reason != licensegrpc.ReasonFeatureMissing || info.Metadata["feature"] != "sso" {
+		t.Error("Unexpected error detail", st.Details()[0])
+	}
+
+	source.license, source.err = nil, lib.ExpiredLicense
+	if st := status.Convert(call("/acme.Reports/Daily")); st.Code() != codes.PermissionDenied {
+		t.Error("Expected PermissionDenied, but found", st)
+	}
+}
+
+func TestTokenClientInterceptor(t *testing.T) {
+	pkey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lic := lib.NewLicense("ACME", time.Now().Add(24*time.Hour))
+	tok, err := lib.IssueToken(lic, pkey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interceptor := licensegrpc.UnaryClientInterceptor(&testTokens{tok})
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		in := metadata.NewIncomingContext(ctx, md)
+		got, err := licensegrpc.TokenFromIncomingContext(in, &pkey.PublicKey)
+		if err != nil {
+			return err
+		}
+		if got.Info.LicenseID != lic.Info.ID {
+			t.Error("Expected token for", lic.Info.ID, "but found", got.Info.LicenseID)
+		}
+		return nil
+	}
+
+	if err := interceptor(context.Background(), "/acme.Reports/Daily", nil, nil, nil, invoker); err != nil {
+		t.Error("Expected nil error, but found", err)
+	}
+
+	if _, err := licensegrpc.TokenFromIncomingContext(context.Background(), &pkey.PublicKey); err != lib.ErrNoToken {
+		t.Error("Expected ErrNoToken, but found", err)
+	}
+}
@@ -0,0 +1,216 @@
+// Package licensehttp enforces licenses in net/http servers. A Guard rejects
+// requests while the license is invalid or lacks the feature a route needs,
+// and serves the license status for monitoring.
+package licensehttp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+// ProblemContentType is the media type of error responses (RFC 7807)
+const ProblemContentType = "application/problem+json"
+
+// Problem types of error responses
+const (
+	ProblemLicenseInvalid = "https://github.com/dewaka/license_gen/problems/license-invalid"
+	ProblemFeatureMissing = "https://github.com/dewaka/license_gen/problems/feature-missing"
+)
+
+const errNoLicense = "No valid license"
+
+// Source provides the current license. *lib.Watcher is a Source that keeps
+// the license up to date.
+type Source interface {
+	// License returns the valid license, or nil when there is none
+	License() *lib.VerifiedLicense
+	// Err returns why there is no valid license
+	Err() error
+}
+
+// staticSource is a Source for a license verified once. Its status is
+// computed again on every call so that it is no longer provided once it
+// expires.
+type staticSource struct {
+	verifier *lib.Verifier
+	license  *lib.VerifiedLicense
+}
+
+func (s staticSource) current() (*lib.VerifiedLicense, error) {
+	st, err := s.verifier.Status(s.license.License)
+	if err != nil {
+		return nil, err
+	}
+
+	license := *s.license
+	license.Status = st
+	return &license, nil
+}
+
+func (s staticSource) License() *lib.VerifiedLicense {
+	license, _ := s.current()
+	return license
+}
+
+func (s staticSource) Err() error {
+	_, err := s.current()
+	return err
+}
+
+// Static returns a Source providing license, which verifier verified, until
+// it expires. The clock and grace period of verifier decide when that is.
+func Static(verifier *lib.Verifier, license *lib.VerifiedLicense) Source {
+	return staticSource{verifier: verifier, license: license}
+}
+
+// Problem - The JSON body of rejected requests
+type Problem struct {
+	Type    string `json:"type"`
+	Title   string `json:"title"`
+	Status  int    `json:"status"`
+	Detail  string `json:"detail,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Feature string `json:"feature,omitempty"`
+}
+
+// Guard checks requests against the license of a Source
+type Guard struct {
+	source Source
+}
+
+// New returns a Guard enforcing the license provided by source
+func New(source Source) *Guard {
+	return &Guard{source: source}
+}
+
+// Middleware rejects requests with 402 Payment Required while there is no
+// valid license
+func (g *Guard) Middleware(next http.Handler) http.Handler {
+	return g.Require("")(next)
+}
+
+// Require returns middleware for routes that need feature. Requests are
+// rejected with 402 Payment Required while there is no valid license and with
+// 403 Forbidden when the license does not grant the feature. An empty feature
+// only requires a valid license.
+func (g *Guard) Require(feature string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			license := g.source.License()
+			if license == nil {
+				writeProblem(w, g.invalidProblem())
+				return
+			}
+
+			if feature != "" && !license.HasFeature(feature) {
+				writeProblem(w, &Problem{
+					Type:    ProblemFeatureMissing,
+					Title:   "Feature not licensed",
+					Status:  http.StatusForbidden,
+					Detail:  "The license does not include the " + feature + " feature",
+					Feature: feature,
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (g *Guard) invalidProblem() *Problem {
+	p := &Problem{
+		Type:   ProblemLicenseInvalid,
+		Title:  "License invalid",
+		Status: http.StatusPaymentRequired,
+		Detail: errNoLicense,
+	}
+
+	if err := g.source.Err(); err != nil {
+		p.Detail = err.Error()
+		var verr *lib.ValidationError
+		if errors.As(err, &verr) {
+			p.Code = verr.Code
+		}
+	}
+
+	return p
+}
+
+func writeProblem(w http.ResponseWriter, p *Problem) {
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// Status - The body served by the status handler
+type Status struct {
+	Valid      bool           `json:"valid"`
+	Status     string         `json:"status,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Code       string         `json:"code,omitempty"`
+	ID         string         `json:"id,omitempty"`
+	Product    string         `json:"product,omitempty"`
+	Licensee   string         `json:"licensee,omitempty"`
+	Expiration *time.Time     `json:"expiration,omitempty"`
+	DaysLeft   *int           `json:"days_left,omitempty"`
+	Features   []string       `json:"features,omitempty"`
+	Limits     map[string]int `json:"limits,omitempty"`
+}
+
+// StatusHandler serves the license status as JSON, typically mounted at
+// /license. It responds with 200 OK while the license is valid and 402
+// Payment Required otherwise, so it can be used as a health check.
+func (g *Guard) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		st, code := g.status()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(st)
+	})
+}
+
+func (g *Guard) status() (*Status, int) {
+	license := g.source.License()
+	if license == nil {
+		p := g.invalidProblem()
+		return &Status{Error: p.Detail, Code: p.Code}, http.StatusPaymentRequired
+	}
+
+	features := append([]string(nil), license.Features...)
+	sort.Strings(features)
+
+	days := license.Status.DaysLeft
+	return &Status{
+		Valid:      true,
+		Status:     license.Status.Status,
+		ID:         license.ID,
+		Product:    license.Product,
+		Licensee:   license.Name,
+		Expiration: &license.Expiration,
+		DaysLeft:   &days,
+		Features:   features,
+		Limits:     license.Limits,
+	}, http.StatusOK
+}
+
+// Handler returns a mux serving the status at /license and everything else
+// from next, guarded by Middleware
+func (g *Guard) Handler(next http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/license", g.StatusHandler())
+	mux.Handle("/", g.Middleware(next))
+	return mux
+}
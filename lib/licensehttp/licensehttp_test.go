@@ -0,0 +1,144 @@
+package licensehttp_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+	"github.com/dewaka/license_gen/lib/licensehttp"
+)
+
+// the watcher keeps the license of a Guard up to date
+var _ licensehttp.Source = (*lib.Watcher)(nil)
+
+type testSource struct {
+	license *lib.VerifiedLicense
+	err     error
+}
+
+func (s *testSource) License() *lib.VerifiedLicense { return s.license }
+func (s *testSource) Err() error                    { return s.err }
+
+func TestGuard(t *testing.T) {
+	pkey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lic := lib.NewLicense("ACME", time.Now().Add(24*time.Hour))
+	lic.Info.Features = []string{"reports"}
+	if err := lic.Sign(pkey); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := lib.NewVerifier(lib.WithPublicKey(&pkey.PublicKey))
+	vl, err := verifier.VerifyLicense(context.Background(), lic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := &testSource{license: vl}
+	guard := licensehttp.New(source)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	mux := http.NewServeMux()
+	mux.Handle("/reports", guard.Require("reports")(ok))
+	mux.Handle("/sso", guard.Require("sso")(ok))
+	mux.Handle("/license", guard.StatusHandler())
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	if rec := get("/reports"); rec.Code != http.StatusOK {
+		t.Error("Expected 200, but found", rec.Code)
+	}
+
+	rec := get("/sso")
+	var p licensehttp.Problem
+	if rec.Code != http.StatusForbidden || json.Unmarshal(rec.Body.Bytes(), &p) != nil || p.Feature != "sso" {
+		t.Error("Expected 403 for a missing feature, but found", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != licensehttp.ProblemContentType {
+		t.Error("Expected problem content type, but found", ct)
+	}
+
+	var st licensehttp.Status
+	if rec := get("/license"); rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &st) != nil || !st.Valid || st.ID != lic.Info.ID {
+		t.Error("Expected a valid status, but found", rec.Code, rec.Body.String())
+	}
+
+	source.license, source.err = nil, lib.ExpiredLicense
+	if rec := get("/reports"); rec.Code != http.StatusPaymentRequired {
+		t.Error("Expected 402, but found", rec.Code)
+	}
+	if rec := get("/license"); rec.Code != http.StatusPaymentRequired {
+		t.Error("Expected 402 status, but found", rec.Code)
+	}
+}
+
+func TestStaticExpiry(t *testing.T) {
+	pkey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expiry := time.Date(2030, 1, 31, 0, 0, 0, 0, time.UTC)
+	lic := lib.NewLicense("ACME", expiry)
+	if err := lic.Sign(pkey); err != nil {
+		t.Fatal(err)
+	}
+
+	now := expiry.Add(-72 * time.Hour)
+	verifier := lib.NewVerifier(lib.WithPublicKey(&pkey.PublicKey),
+		lib.WithClock(func() time.Time { return now }), lib.WithGracePeriod(24*time.Hour))
+	vl, err := verifier.VerifyLicense(context.Background(), lic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guard := licensehttp.New(licensehttp.Static(verifier, vl))
+	handler := guard.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+	status := func() licensehttp.Status {
+		var st licensehttp.Status
+		rec := get("/license")
+		if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+			t.Fatal("Failed to decode status:", err, rec.Body.String())
+		}
+		return st
+	}
+
+	if st := status(); st.DaysLeft == nil || *st.DaysLeft != 3 || st.Status != lib.StatusValid {
+		t.Error("Expected 3 days left, but found", st)
+	}
+
+	// the status follows the clock rather than the time of verification
+	now = expiry.Add(12 * time.Hour)
+	if rec := get("/"); rec.Code != http.StatusOK {
+		t.Error("Expected 200 in the grace period, but found", rec.Code)
+	}
+	if st := status(); st.Status != lib.StatusGrace {
+		t.Error("Expected grace status, but found", st)
+	}
+
+	now = expiry.Add(48 * time.Hour)
+	if rec := get("/"); rec.Code != http.StatusPaymentRequired {
+		t.Error("Expected 402 once expired, but found", rec.Code)
+	}
+	if st := status(); st.Valid || st.Code != lib.CodeExpired {
+		t.Error("Expected expired status, but found", st)
+	}
+}
@@ -1,11 +1,14 @@
 package lib
 
 import (
+	"context"
 	"crypto"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -22,23 +25,97 @@ var (
 	ErrorPubKeyRead  = errors.New("Could not read public key")
 	InvalidLicense   = errors.New("Invalid License file")
 	ExpiredLicense   = errors.New("License expired")
+
+	ErrLicenseNotYetValid = errors.New("License not yet valid")
+	ErrWrongProduct       = errors.New("License is for a different product")
 )
 
 // LicenseInfo - Core information about a license
 type LicenseInfo struct {
-	Name       string    `json:"name"`
-	Expiration time.Time `json:"expiration"`
+	ID         string    `json:"id,omitempty" yaml:"id,omitempty" toml:"id,omitempty"`
+	Product    string    `json:"product,omitempty" yaml:"product,omitempty" toml:"product,omitempty"`
+	Issuer     string    `json:"issuer,omitempty" yaml:"issuer,omitempty" toml:"issuer,omitempty"`
+	Name       string    `json:"name" yaml:"name" toml:"name"`
+	Expiration time.Time `json:"expiration" yaml:"expiration" toml:"expiration"`
+	// NotBefore is optional, licenses are valid from issue when not set
+	NotBefore *time.Time `json:"not_before,omitempty" yaml:"not_before,omitempty" toml:"not_before,omitempty"`
+
+	Features []string          `json:"features,omitempty" yaml:"features,omitempty" toml:"features,omitempty"`
+	Limits   map[string]int    `json:"limits,omitempty" yaml:"limits,omitempty" toml:"limits,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty" toml:"metadata,omitempty"`
+
+	// Predecessor is the ID of the license this one renews
+	Predecessor string `json:"predecessor,omitempty" yaml:"predecessor,omitempty" toml:"predecessor,omitempty"`
+}
+
+// HasFeature reports whether the license grants the named feature
+func (info *LicenseInfo) HasFeature(feature string) bool {
+	for _, f := range info.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Limit returns the named limit (such as seats) of the license and whether it
+// is set at all
+func (info *LicenseInfo) Limit(name string) (int, bool) {
+	v, ok := info.Limits[name]
+	return v, ok
 }
 
 // LicenseData - This is the license data we serialise into a license file
 type LicenseData struct {
-	Info LicenseInfo `json:"info"`
-	Key  string      `json:"key"`
+	Info  LicenseInfo     `json:"info" yaml:"info" toml:"info"`
+	KeyID string          `json:"key_id,omitempty" yaml:"key_id,omitempty" toml:"key_id,omitempty"`
+	Key   string          `json:"key" yaml:"key" toml:"key"`
+	Proof *InclusionProof `json:"proof,omitempty" yaml:"proof,omitempty" toml:"proof,omitempty"`
+
+	// jwt and cose are the token or message a JWT or COSE license was read
+	// from, which carry the signature in place of Key
+	jwt  string
+	cose []byte
 }
 
 // NewLicense from given info
 func NewLicense(name string, expiry time.Time) *LicenseData {
-	return &LicenseData{Info: LicenseInfo{Name: name, Expiration: expiry}}
+	return &LicenseData{Info: LicenseInfo{ID: NewLicenseID(), Name: name, Expiration: expiry}}
+}
+
+// NewLicenseID returns a random identifier suitable for LicenseInfo.ID
+func NewLicenseID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Renew returns an unsigned copy of the license with a new ID and expiry,
+// recording this license as its predecessor
+func (lic *LicenseData) Renew(expiry time.Time) *LicenseData {
+	info := lic.Info
+	info.ID = NewLicenseID()
+	info.Expiration = expiry
+	info.Predecessor = lic.Info.ID
+	info.NotBefore = nil
+
+	info.Features = append([]string(nil), lic.Info.Features...)
+	if lic.Info.Limits != nil {
+		info.Limits = make(map[string]int, len(lic.Info.Limits))
+		for k, v := range lic.Info.Limits {
+			info.Limits[k] = v
+		}
+	}
+	if lic.Info.Metadata != nil {
+		info.Metadata = make(map[string]string, len(lic.Info.Metadata))
+		for k, v := range lic.Info.Metadata {
+			info.Metadata[k] = v
+		}
+	}
+
+	return &LicenseData{Info: info}
 }
 
 func encodeKey(keyData []byte) string {
@@ -51,17 +128,36 @@ func decodeKey(keyStr string) ([]byte, error) {
 
 // Sign the License by updating the LicenseData.Key with given RSA private key
 func (lic *LicenseData) Sign(pkey *rsa.PrivateKey) error {
-	jsonLicInfo, err := json.Marshal(lic.Info)
+	return lic.SignWithSigner(pkey)
+}
+
+// SignWithSigner signs the License with a crypto.Signer holding an RSA key,
+// such as a key kept in a KMS or HSM
+func (lic *LicenseData) SignWithSigner(signer crypto.Signer) error {
+	pub, ok := signer.Public().(*rsa.PublicKey)
+	if !ok {
+		return ErrSignerKeyType
+	}
+
+	jsonLicInfo, err := lic.CanonicalPayload()
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256(jsonLicInfo)
+	signedData, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
 	if err != nil {
 		return err
 	}
 
-	signedData, err := Sign(pkey, jsonLicInfo)
+	keyID, err := KeyID(pub)
 	if err != nil {
 		return err
 	}
 
 	lic.Key = encodeKey(signedData)
+	lic.KeyID = keyID
+	lic.jwt, lic.cose = "", nil
 
 	return nil
 }
@@ -78,12 +174,20 @@ func (lic *LicenseData) SignWithKey(privKey string) error {
 }
 
 func (lic *LicenseData) ValidateLicenseKeyWithPublicKey(publicKey *rsa.PublicKey) error {
+	if lic.jwt != "" {
+		return lic.verifyJWT(publicKey)
+	}
+	if lic.cose != nil {
+		// see ValidateCOSE and WithEd25519Key
+		return ErrCOSEKeyType
+	}
+
 	signedData, err := decodeKey(lic.Key)
 	if err != nil {
 		return err
 	}
 
-	jsonLicInfo, err := json.Marshal(lic.Info)
+	jsonLicInfo, err := lic.CanonicalPayload()
 	if err != nil {
 		return err
 	}
@@ -104,10 +208,11 @@ func (lic *LicenseData) ValidateLicenseKey(pubKey string) error {
 	return lic.ValidateLicenseKeyWithPublicKey(publicKey)
 }
 
-// CheckLicenseInfo checks license for logical errors such as for license expiry
+// CheckLicenseInfo checks license for logical errors such as for license expiry.
+// Failures are returned as a *ValidationError.
 func (lic *LicenseData) CheckLicenseInfo() error {
-	if time.Now().After(lic.Info.Expiration) {
-		return ExpiredLicense
+	if problems := lic.checkInfo(time.Now(), 0); len(problems) > 0 {
+		return problems[0]
 	}
 
 	return nil
@@ -132,18 +237,30 @@ func (lic *LicenseData) SaveLicenseToFile(licName string) error {
 	return ioutil.WriteFile(licName, jsonLic, 0644)
 }
 
+// ReadLicense reads a license in any of the formats DetectFormat knows
 func ReadLicense(r io.Reader) (*LicenseData, error) {
 	ldata, err := ioutil.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
 
-	var license LicenseData
-	if err := json.Unmarshal(ldata, &license); err != nil {
-		return nil, err
-	}
+	return decodeLicense(ldata)
+}
 
-	return &license, nil
+// decodeLicense decodes a license in any of the formats DetectFormat knows
+func decodeLicense(ldata []byte) (*LicenseData, error) {
+	switch format := DetectFormat(ldata); format {
+	case FormatJWT:
+		return decodeJWT(ldata)
+	case FormatCOSE:
+		return decodeCOSE(ldata)
+	default:
+		codec, err := CodecFor(format)
+		if err != nil {
+			return nil, err
+		}
+		return codec.Decode(ldata)
+	}
 }
 
 func ReadLicenseFromFile(licFile string) (*LicenseData, error) {
@@ -265,22 +382,222 @@ func TestLicensing(privKey, pubKey string) error {
 	return nil
 }
 
-// CheckLicenseFile reads a license from lr and then validate it against the
-// public key read from pkr
-func CheckLicense(lr, pkr io.Reader) error {
-	lic, err := ReadLicense(lr)
+// VerifyOption configures the checks performed by a Verifier or CheckLicense
+type VerifyOption func(*verifyOptions)
+
+type verifyOptions struct {
+	revocations   *RevocationList
+	revocationSrc string
+	revocationKey *rsa.PublicKey
+	refresh       time.Duration
+	minSequence   uint64
+	sequenceFile  string
+	crl           *revocationCache
+
+	trust       []*TrustStore
+	keys        []*rsa.PublicKey
+	edKeys      []ed25519.PublicKey
+	clock       func() time.Time
+	product     string
+	fingerprint string
+	grace       time.Duration
+}
+
+// WithRevocationList rejects licenses revoked by rl. The list must be signed by
+// the trusted key named by its key_id, or by the WithRevocationKey key.
+func WithRevocationList(rl *RevocationList) VerifyOption {
+	return func(o *verifyOptions) {
+		o.revocations = rl
+	}
+}
+
+// WithRevocationListFrom loads the revocation list from a file path or an
+// http(s) URL on the first check and again once it is older than the refresh
+// interval. When loading it again fails the previous list stays in use.
+func WithRevocationListFrom(src string) VerifyOption {
+	return func(o *verifyOptions) {
+		o.revocationSrc = src
+	}
+}
+
+// WithRevocationRefresh sets how long a list loaded by WithRevocationListFrom
+// is used before it is loaded again, DefaultRevocationRefresh by default
+func WithRevocationRefresh(d time.Duration) VerifyOption {
+	return func(o *verifyOptions) {
+		o.refresh = d
+	}
+}
+
+// WithRevocationKey accepts only revocation lists signed by key, which need
+// not be trusted to sign licenses. Without it a list signed by any trusted
+// RSA key is accepted, including a key the list revokes.
+func WithRevocationKey(key *rsa.PublicKey) VerifyOption {
+	return func(o *verifyOptions) {
+		o.revocationKey = key
+	}
+}
+
+// WithMinRevocationSequence rejects revocation lists older than seq, so that
+// an attacker cannot replay an earlier list to un-revoke a license
+func WithMinRevocationSequence(seq uint64) VerifyOption {
+	return func(o *verifyOptions) {
+		o.minSequence = seq
+	}
+}
+
+// WithRevocationStateFile rejects revocation lists older than the last one
+// seen, whose sequence is kept in the state file path. The file is read on the
+// first check and written whenever a newer signed list verifies.
+func WithRevocationStateFile(path string) VerifyOption {
+	return func(o *verifyOptions) {
+		o.sequenceFile = path
+	}
+}
+
+// revocationList returns the configured revocation list, loading it from the
+// revocation source when the cached list is missing or out of date
+func (o *verifyOptions) revocationList(ctx context.Context) (*RevocationList, error) {
+	if o.revocations != nil || o.revocationSrc == "" {
+		return o.revocations, nil
+	}
+
+	refresh := o.refresh
+	if refresh <= 0 {
+		refresh = DefaultRevocationRefresh
+	}
+
+	c := o.crl
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := o.now()
+	if c.list != nil && now.Sub(c.loaded) < refresh {
+		return c.list, nil
+	}
+
+	rl, err := LoadRevocationListContext(ctx, o.revocationSrc)
 	if err != nil {
-		return ErrorLicenseRead
+		if c.list != nil {
+			c.loaded = now
+			return c.list, nil
+		}
+		return nil, err
 	}
 
-	publicKey, err := ReadPublicKey(pkr)
+	c.list, c.loaded = rl, now
+	return rl, nil
+}
+
+// checkSequence rejects rl if it is older than the minimum sequence, the
+// state file or any list verified before, and records its sequence when it is
+// newer
+func (o *verifyOptions) checkSequence(rl *RevocationList) error {
+	c := o.crl
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if o.sequenceFile != "" && !c.stateRead {
+		seen, err := ReadRevocationSequence(o.sequenceFile)
+		if err != nil {
+			return err
+		}
+		if seen > c.seen {
+			c.seen = seen
+		}
+		c.stateRead = true
+	}
+
+	minSequence := o.minSequence
+	if c.seen > minSequence {
+		minSequence = c.seen
+	}
+	if err := rl.CheckSequence(minSequence); err != nil {
+		return err
+	}
+
+	if rl.Info.Sequence > c.seen {
+		if o.sequenceFile != "" {
+			if err := SaveRevocationSequence(o.sequenceFile, rl.Info.Sequence); err != nil {
+				return err
+			}
+		}
+		c.seen = rl.Info.Sequence
+	}
+	return nil
+}
+
+// checkRevocation verifies the configured revocation list (if any) and
+// reports whether lic or signer, the key its signature verified with, has
+// been revoked
+func (o *verifyOptions) checkRevocation(ctx context.Context, lic *LicenseData, signer trustedKey) *ValidationError {
+	rl, err := o.revocationList(ctx)
+	if err != nil {
+		return newValidationError(CodeReadError, "revocation_list", nil, err)
+	}
+	if rl == nil {
+		return nil
+	}
+
+	listKey, err := o.revocationListKey(rl)
+	if err != nil {
+		return newValidationError(CodeInvalidRevocationList, "revocation_list", nil, err)
+	}
+	if err := rl.Verify(listKey); err != nil {
+		return newValidationError(CodeInvalidRevocationList, "revocation_list", nil, err)
+	}
+
+	if err := o.checkSequence(rl); err != nil {
+		if errors.Is(err, ErrRevocationListRollback) {
+			return newValidationError(CodeInvalidRevocationList, "revocation_list", nil, err)
+		}
+		return newValidationError(CodeReadError, "revocation_list", nil, err)
+	}
+
+	if err := rl.Check(lic, signer.id); err != nil {
+		return newValidationError(CodeRevoked, "id", nil, err)
+	}
+
+	return nil
+}
+
+// revocationListKey returns the key rl must be signed with: the revocation
+// key if one is set, otherwise the trusted RSA key named by the key_id of the
+// list. Lists are signed independently of licenses, so one list covers
+// licenses signed by every trusted key and can revoke the key it is not
+// signed with.
+func (o *verifyOptions) revocationListKey(rl *RevocationList) (*rsa.PublicKey, error) {
+	if o.revocationKey != nil {
+		return o.revocationKey, nil
+	}
+
+	keys, err := o.trustedKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if k.key != nil && k.id == rl.KeyID {
+			return k.key, nil
+		}
+	}
+	return nil, ErrUntrustedKey
+}
+
+// CheckLicenseFile reads a license from lr and then validate it against the
+// public key read from pkr. Failures are returned as a *ValidationError, the
+// first problem found when there are several. Use ValidateLicense to get all
+// of them.
+func CheckLicense(lr, pkr io.Reader, opts ...VerifyOption) error {
+	licenseBytes, err := ioutil.ReadAll(lr)
 	if err != nil {
-		return ErrorPubKeyRead
+		return newValidationError(CodeReadError, "license", ErrorLicenseRead, err)
 	}
 
-	if err := lic.ValidateLicenseKeyWithPublicKey(publicKey); err != nil {
-		return InvalidLicense // we have a key mismatch here meaning license data is tampered
+	publicKey, err := ReadPublicKey(pkr)
+	if err != nil {
+		return newValidationError(CodeReadError, "public_key", ErrorPubKeyRead, err)
 	}
 
-	return lic.CheckLicenseInfo()
+	opts = append([]VerifyOption{WithPublicKey(publicKey)}, opts...)
+	_, err = NewVerifier(opts...).Verify(context.Background(), licenseBytes)
+	return err
 }
@@ -106,3 +106,44 @@ func TestCheckLicense(t *testing.T) {
 		t.Errorf("Expected nil error, but found %s\n", err)
 	}
 }
+
+func TestLicenseFeaturesAndLimits(t *testing.T) {
+	lic := lib.NewLicense("Chathura Colombage", time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	lic.Info.Features = []string{"sso", "audit"}
+	lic.Info.Limits = map[string]int{"seats": 50}
+
+	if !lic.Info.HasFeature("sso") || lic.Info.HasFeature("reports") {
+		t.Error("HasFeature does not match the license features!")
+	}
+
+	if seats, ok := lic.Info.Limit("seats"); !ok || seats != 50 {
+		t.Error("Expected 50 seats, but found", seats)
+	}
+	if _, ok := lic.Info.Limit("projects"); ok {
+		t.Error("Expected projects limit to be unset!")
+	}
+}
+
+func TestRenewLicense(t *testing.T) {
+	lic := lib.NewLicense("Chathura Colombage", time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	lic.Info.Limits = map[string]int{"seats": 10}
+	lic.Key = "signature"
+
+	expiry := time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC)
+	renewed := lic.Renew(expiry)
+
+	if renewed.Info.ID == lic.Info.ID || renewed.Info.Predecessor != lic.Info.ID {
+		t.Error("Renewed license must have a new ID and record its predecessor!")
+	}
+	if !renewed.Info.Expiration.Equal(expiry) || renewed.Info.Name != lic.Info.Name {
+		t.Error("Renewed license info does not match!")
+	}
+	if renewed.Key != "" {
+		t.Error("Renewed license must not carry the old signature!")
+	}
+
+	renewed.Info.Limits["seats"] = 20
+	if lic.Info.Limits["seats"] != 10 {
+		t.Error("Changing the renewed license modified the original!")
+	}
+}
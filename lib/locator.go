@@ -0,0 +1,190 @@
+package lib
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LicenseKeyEnv is the environment variable LicenseLocator reads a license
+// from by default
+const LicenseKeyEnv = "LICENSE_KEY"
+
+// License sources, in the order LicenseLocator searches them
+const (
+	SourcePath         = "path"
+	SourceEnv          = "env"
+	SourceUserConfig   = "user_config"
+	SourceSystemConfig = "system_config"
+	SourceDir          = "dir"
+)
+
+// ErrNoLicenseFound is returned when no source holds a license
+var ErrNoLicenseFound = errors.New("No license found")
+
+// LicenseLocator - Finds the license of an application in an ordered list of
+// sources:
+//
+//   - Path, when set
+//   - the LICENSE_KEY environment variable, holding a license or a base64
+//     encoded license
+//   - $XDG_CONFIG_HOME/<product>/license, ~/.config when XDG_CONFIG_HOME is
+//     not set
+//   - /etc/<product>/license
+//   - the most recently modified *.lic file in Dir, when set
+//
+// The first source holding a license wins, even if that license turns out to
+// be invalid. The config file sources need Product.
+type LicenseLocator struct {
+	Product string
+	Path    string
+	Dir     string
+
+	// EnvVar replaces LICENSE_KEY
+	EnvVar string
+	// SystemConfigDir replaces /etc
+	SystemConfigDir string
+}
+
+// LocatedLicense - A license and the source it was found in
+type LocatedLicense struct {
+	License *LicenseData
+	// Source is one of the Source constants
+	Source string
+	// Location is the file or environment variable read
+	Location string
+}
+
+func (ll *LocatedLicense) String() string {
+	return ll.Source + " " + ll.Location
+}
+
+// Locate reads the license from the first source holding one. An explicit
+// Path that does not exist is an error rather than skipped.
+func (l *LicenseLocator) Locate() (*LocatedLicense, error) {
+	if l.Path != "" {
+		return l.readFile(SourcePath, l.Path)
+	}
+
+	envVar := l.EnvVar
+	if envVar == "" {
+		envVar = LicenseKeyEnv
+	}
+	if value := strings.TrimSpace(os.Getenv(envVar)); value != "" {
+		data, err := decodeEnvLicense(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", envVar, err)
+		}
+		lic, err := decodeLicense(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", envVar, err)
+		}
+		return &LocatedLicense{License: lic, Source: SourceEnv, Location: envVar}, nil
+	}
+
+	if l.Product != "" {
+		if dir := userConfigDir(); dir != "" {
+			if ll, err := l.readFile(SourceUserConfig, filepath.Join(dir, l.Product, "license")); !os.IsNotExist(err) {
+				return ll, err
+			}
+		}
+
+		sysDir := l.SystemConfigDir
+		if sysDir == "" {
+			sysDir = "/etc"
+		}
+		if ll, err := l.readFile(SourceSystemConfig, filepath.Join(sysDir, l.Product, "license")); !os.IsNotExist(err) {
+			return ll, err
+		}
+	}
+
+	if l.Dir != "" {
+		path, err := newestFile(filepath.Join(l.Dir, "*.lic"))
+		if err != nil {
+			return nil, err
+		}
+		if path != "" {
+			return l.readFile(SourceDir, path)
+		}
+	}
+
+	return nil, ErrNoLicenseFound
+}
+
+// Verify locates the license and verifies it with v
+func (l *LicenseLocator) Verify(ctx context.Context, v *Verifier) (*VerifiedLicense, *LocatedLicense, error) {
+	ll, err := l.Locate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	vl, err := v.VerifyLicense(ctx, ll.License)
+	return vl, ll, err
+}
+
+func (l *LicenseLocator) readFile(source, path string) (*LocatedLicense, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lic, err := decodeLicense(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &LocatedLicense{License: lic, Source: source, Location: path}, nil
+}
+
+// decodeEnvLicense returns the license in an environment variable, either
+// as is or base64 encoded
+func decodeEnvLicense(value string) ([]byte, error) {
+	data := []byte(value)
+	if data[0] == '{' || DetectFormat(data) != FormatJSON {
+		return data, nil
+	}
+
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if decoded, err := enc.DecodeString(value); err == nil {
+			return decoded, nil
+		}
+	}
+	return nil, errors.New("neither a license nor a base64 encoded license")
+}
+
+// userConfigDir returns the XDG user config directory
+func userConfigDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return dir
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config")
+	}
+	return ""
+}
+
+// newestFile returns the most recently modified file matching pattern, empty
+// if none does
+func newestFile(pattern string) (string, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return "", err
+	}
+
+	var newest string
+	var newestInfo os.FileInfo
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if newestInfo == nil || info.ModTime().After(newestInfo.ModTime()) {
+			newest, newestInfo = m, info
+		}
+	}
+	return newest, nil
+}
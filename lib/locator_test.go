@@ -0,0 +1,106 @@
+package lib_test
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func TestLicenseLocator(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+
+	root, err := ioutil.TempDir("", "locator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	save := func(path, name string) []byte {
+		lic := lib.NewLicense(name, time.Now().Add(24*time.Hour))
+		if err := lic.Sign(pkey); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := lic.SaveLicenseToFile(path); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadFile(path)
+		return data
+	}
+
+	xdg := filepath.Join(root, "config")
+	oldXDG, hadXDG := os.LookupEnv("XDG_CONFIG_HOME")
+	os.Setenv("XDG_CONFIG_HOME", xdg)
+	defer func() {
+		if hadXDG {
+			os.Setenv("XDG_CONFIG_HOME", oldXDG)
+		} else {
+			os.Unsetenv("XDG_CONFIG_HOME")
+		}
+	}()
+	const envVar = "LICENSE_GEN_TEST_LICENSE_KEY"
+	defer os.Unsetenv(envVar)
+
+	locator := &lib.LicenseLocator{
+		Product:         "app",
+		Dir:             filepath.Join(root, "licenses"),
+		EnvVar:          envVar,
+		SystemConfigDir: filepath.Join(root, "etc"),
+	}
+
+	if _, err := locator.Locate(); err != lib.ErrNoLicenseFound {
+		t.Error("Expected ErrNoLicenseFound, but found", err)
+	}
+
+	// each source added takes precedence over the ones before
+	save(filepath.Join(root, "licenses", "old.lic"), "Old")
+	hourAgo := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(root, "licenses", "old.lic"), hourAgo, hourAgo)
+	save(filepath.Join(root, "licenses", "new.lic"), "Dir")
+	save(filepath.Join(root, "etc", "app", "license"), "System")
+	save(filepath.Join(xdg, "app", "license"), "User")
+	env := save(filepath.Join(root, "env.json"), "Env")
+
+	expect := func(source, name string) {
+		t.Helper()
+		ll, err := locator.Locate()
+		if err != nil {
+			t.Fatal("Failed to locate license:", err)
+		}
+		if ll.Source != source || ll.License.Info.Name != name {
+			t.Errorf("Expected %s license from %s, but found %s license from %s", name, source, ll.License.Info.Name, ll)
+		}
+		if err := ll.License.ValidateLicenseKeyWithPublicKey(&pkey.PublicKey); err != nil {
+			t.Error("Expected nil error, but found", err)
+		}
+	}
+
+	expect(lib.SourceUserConfig, "User")
+	os.Remove(filepath.Join(xdg, "app", "license"))
+	expect(lib.SourceSystemConfig, "System")
+	os.Remove(filepath.Join(root, "etc", "app", "license"))
+	expect(lib.SourceDir, "Dir")
+
+	os.Setenv(envVar, base64.StdEncoding.EncodeToString(env))
+	expect(lib.SourceEnv, "Env")
+	os.Setenv(envVar, string(env))
+	expect(lib.SourceEnv, "Env")
+
+	locator.Path = filepath.Join(root, "env.json")
+	expect(lib.SourcePath, "Env")
+	locator.Path = filepath.Join(root, "missing.json")
+	if _, err := locator.Locate(); !os.IsNotExist(err) {
+		t.Error("Expected a missing explicit path to be an error, but found", err)
+	}
+}
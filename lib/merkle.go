@@ -0,0 +1,459 @@
+package lib
+
+// Merkle tree transparency log of issued licenses, following the tree
+// structure, inclusion proofs and consistency proofs of RFC 6962 (and the
+// verification algorithms of RFC 9162).
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transparency log errors
+var (
+	ErrInvalidProof     = errors.New("Invalid Merkle proof")
+	ErrInvalidTreeHead  = errors.New("Invalid signed tree head")
+	ErrNoInclusionProof = errors.New("License has no inclusion proof")
+	ErrTreeSize         = errors.New("Requested tree size is out of range")
+)
+
+// Domain separation prefixes from RFC 6962 section 2.1
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// MerkleLeafHash returns the RFC 6962 hash of a leaf with the given data
+func MerkleLeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func merkleNodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// LicenseLeafHash returns the transparency log leaf hash of a signed license.
+// The leaf covers the license information and its signature; the embedded
+// proof is not part of it.
+func LicenseLeafHash(lic *LicenseData) ([]byte, error) {
+	leaf, err := json.Marshal(struct {
+		Info LicenseInfo `json:"info"`
+		Key  string      `json:"key"`
+	}{lic.Info, lic.Key})
+	if err != nil {
+		return nil, err
+	}
+
+	return MerkleLeafHash(leaf), nil
+}
+
+// largestPowerOfTwoBelow returns the largest power of two smaller than n, n > 1
+func largestPowerOfTwoBelow(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// MerkleTreeHash computes the root hash of a tree over the given leaf hashes
+func MerkleTreeHash(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	case 1:
+		return leaves[0]
+	}
+
+	k := largestPowerOfTwoBelow(len(leaves))
+	return merkleNodeHash(MerkleTreeHash(leaves[:k]), MerkleTreeHash(leaves[k:]))
+}
+
+// MerkleInclusionProof returns the audit path for leaf index m in the tree
+// over leaves
+func MerkleInclusionProof(m int, leaves [][]byte) [][]byte {
+	n := len(leaves)
+	if n <= 1 {
+		return [][]byte{}
+	}
+
+	k := largestPowerOfTwoBelow(n)
+	if m < k {
+		return append(MerkleInclusionProof(m, leaves[:k]), MerkleTreeHash(leaves[k:]))
+	}
+	return append(MerkleInclusionProof(m-k, leaves[k:]), MerkleTreeHash(leaves[:k]))
+}
+
+// MerkleConsistencyProof returns the proof that the tree over the first m
+// leaves is a prefix of the tree over all leaves
+func MerkleConsistencyProof(m int, leaves [][]byte) [][]byte {
+	if m == 0 || m == len(leaves) {
+		return [][]byte{}
+	}
+	return merkleSubproof(m, leaves, true)
+}
+
+func merkleSubproof(m int, leaves [][]byte, complete bool) [][]byte {
+	n := len(leaves)
+	if m == n {
+		if complete {
+			return [][]byte{}
+		}
+		return [][]byte{MerkleTreeHash(leaves)}
+	}
+
+	k := largestPowerOfTwoBelow(n)
+	if m <= k {
+		return append(merkleSubproof(m, leaves[:k], complete), MerkleTreeHash(leaves[k:]))
+	}
+	return append(merkleSubproof(m-k, leaves[k:], false), MerkleTreeHash(leaves[:k]))
+}
+
+// VerifyMerkleInclusion checks that leafHash is at index in the tree of the
+// given size and root
+func VerifyMerkleInclusion(leafHash []byte, index, size uint64, proof [][]byte, root []byte) error {
+	if index >= size {
+		return ErrInvalidProof
+	}
+
+	fn, sn := index, size-1
+	r := leafHash
+	for _, p := range proof {
+		if sn == 0 {
+			return ErrInvalidProof
+		}
+		if fn&1 == 1 || fn == sn {
+			r = merkleNodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = merkleNodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(r, root) {
+		return ErrInvalidProof
+	}
+	return nil
+}
+
+// VerifyMerkleConsistency checks that the tree of size first and root
+// firstRoot is a prefix of the tree of size second and root secondRoot
+func VerifyMerkleConsistency(first, second uint64, firstRoot, secondRoot []byte, proof [][]byte) error {
+	switch {
+	case first > second:
+		return ErrInvalidProof
+	case first == second:
+		if len(proof) != 0 || !bytes.Equal(firstRoot, secondRoot) {
+			return ErrInvalidProof
+		}
+		return nil
+	case first == 0:
+		// the empty tree is a prefix of every tree
+		if len(proof) != 0 {
+			return ErrInvalidProof
+		}
+		return nil
+	}
+
+	if first&(first-1) == 0 {
+		proof = append([][]byte{firstRoot}, proof...)
+	}
+	if len(proof) == 0 {
+		return ErrInvalidProof
+	}
+
+	fn, sn := first-1, second-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return ErrInvalidProof
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = merkleNodeHash(c, fr)
+			sr = merkleNodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = merkleNodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(fr, firstRoot) || !bytes.Equal(sr, secondRoot) {
+		return ErrInvalidProof
+	}
+	return nil
+}
+
+// TreeHead - The signed part of a signed tree head
+type TreeHead struct {
+	TreeSize  uint64    `json:"tree_size" yaml:"tree_size" toml:"tree_size"`
+	RootHash  []byte    `json:"root_hash" yaml:"root_hash" toml:"root_hash"`
+	Timestamp time.Time `json:"timestamp" yaml:"timestamp" toml:"timestamp"`
+}
+
+// SignedTreeHead - A tree head signed by the log operator
+type SignedTreeHead struct {
+	Head  TreeHead `json:"head" yaml:"head" toml:"head"`
+	KeyID string   `json:"key_id,omitempty" yaml:"key_id,omitempty" toml:"key_id,omitempty"`
+	Key   string   `json:"key" yaml:"key" toml:"key"`
+}
+
+// SignTreeHead signs the given tree head with an RSA private key
+func SignTreeHead(head TreeHead, pkey *rsa.PrivateKey) (*SignedTreeHead, error) {
+	jsonHead, err := json.Marshal(head)
+	if err != nil {
+		return nil, err
+	}
+
+	signedData, err := Sign(pkey, jsonHead)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, err := KeyID(&pkey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SignedTreeHead{Head: head, KeyID: keyID, Key: encodeKey(signedData)}, nil
+}
+
+// Verify checks the tree head signature against the given public key
+func (sth *SignedTreeHead) Verify(publicKey *rsa.PublicKey) error {
+	signedData, err := decodeKey(sth.Key)
+	if err != nil {
+		return ErrInvalidTreeHead
+	}
+
+	jsonHead, err := json.Marshal(sth.Head)
+	if err != nil {
+		return err
+	}
+
+	if err := Unsign(publicKey, jsonHead, signedData); err != nil {
+		return ErrInvalidTreeHead
+	}
+	return nil
+}
+
+// InclusionProof - Proof that a license is included in the transparency log,
+// shipped inside the license file
+type InclusionProof struct {
+	LeafIndex uint64          `json:"leaf_index" yaml:"leaf_index" toml:"leaf_index"`
+	Hashes    [][]byte        `json:"hashes" yaml:"hashes" toml:"hashes"`
+	TreeHead  *SignedTreeHead `json:"tree_head" yaml:"tree_head" toml:"tree_head"`
+}
+
+// VerifyInclusion checks that the license is included in the transparency
+// log, using the embedded proof and a tree head signed by publicKey
+func (lic *LicenseData) VerifyInclusion(publicKey *rsa.PublicKey) error {
+	if lic.Proof == nil || lic.Proof.TreeHead == nil {
+		return ErrNoInclusionProof
+	}
+
+	sth := lic.Proof.TreeHead
+	if err := sth.Verify(publicKey); err != nil {
+		return err
+	}
+
+	leaf, err := LicenseLeafHash(lic)
+	if err != nil {
+		return err
+	}
+
+	return VerifyMerkleInclusion(leaf, lic.Proof.LeafIndex, sth.Head.TreeSize, lic.Proof.Hashes, sth.Head.RootHash)
+}
+
+// ConsistencyProof - Proof that an older tree head is a prefix of a newer one
+type ConsistencyProof struct {
+	OldSize uint64   `json:"old_size"`
+	NewSize uint64   `json:"new_size"`
+	Hashes  [][]byte `json:"hashes"`
+}
+
+// VerifyTreeHeads checks that newer extends older, with both tree heads
+// signed by publicKey. This is how an auditor detects a log that has been
+// rewritten to hide or remove licenses.
+func VerifyTreeHeads(older, newer *SignedTreeHead, proof *ConsistencyProof, publicKey *rsa.PublicKey) error {
+	if err := older.Verify(publicKey); err != nil {
+		return err
+	}
+	if err := newer.Verify(publicKey); err != nil {
+		return err
+	}
+	if proof.OldSize != older.Head.TreeSize || proof.NewSize != newer.Head.TreeSize {
+		return ErrInvalidProof
+	}
+
+	return VerifyMerkleConsistency(older.Head.TreeSize, newer.Head.TreeSize,
+		older.Head.RootHash, newer.Head.RootHash, proof.Hashes)
+}
+
+// TransparencyLog is an append-only log of license leaf hashes stored in a
+// file, one hex encoded hash per line. It is safe for concurrent use within a
+// process.
+type TransparencyLog struct {
+	path string
+
+	mu     sync.Mutex
+	leaves [][]byte
+}
+
+// OpenTransparencyLog loads the log at path, creating it on first append
+func OpenTransparencyLog(path string) (*TransparencyLog, error) {
+	tl := &TransparencyLog{path: path}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return tl, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		leaf, err := hex.DecodeString(line)
+		if err != nil || len(leaf) != sha256.Size {
+			return nil, fmt.Errorf("Transparency log entry %d is corrupt", len(tl.leaves))
+		}
+		tl.leaves = append(tl.leaves, leaf)
+	}
+
+	return tl, scanner.Err()
+}
+
+// Size returns the number of leaves in the log
+func (tl *TransparencyLog) Size() uint64 {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	return uint64(len(tl.leaves))
+}
+
+// Append adds the license to the log and returns its leaf index
+func (tl *TransparencyLog) Append(lic *LicenseData) (uint64, error) {
+	leaf, err := LicenseLeafHash(lic)
+	if err != nil {
+		return 0, err
+	}
+
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	file, err := os.OpenFile(tl.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	if _, err := fmt.Fprintln(file, hex.EncodeToString(leaf)); err != nil {
+		return 0, err
+	}
+	if err := file.Sync(); err != nil {
+		return 0, err
+	}
+
+	tl.leaves = append(tl.leaves, leaf)
+	return uint64(len(tl.leaves) - 1), nil
+}
+
+// TreeHead signs the head of the tree with the first size leaves
+func (tl *TransparencyLog) TreeHead(size uint64, pkey *rsa.PrivateKey) (*SignedTreeHead, error) {
+	tl.mu.Lock()
+	if size > uint64(len(tl.leaves)) {
+		tl.mu.Unlock()
+		return nil, ErrTreeSize
+	}
+	root := MerkleTreeHash(tl.leaves[:size])
+	tl.mu.Unlock()
+
+	return SignTreeHead(TreeHead{TreeSize: size, RootHash: root, Timestamp: time.Now().UTC()}, pkey)
+}
+
+// InclusionProof returns the proof of inclusion of leaf index in the tree
+// with the first size leaves, together with its signed tree head
+func (tl *TransparencyLog) InclusionProof(index, size uint64, pkey *rsa.PrivateKey) (*InclusionProof, error) {
+	sth, err := tl.TreeHead(size, pkey)
+	if err != nil {
+		return nil, err
+	}
+	if index >= size {
+		return nil, ErrTreeSize
+	}
+
+	tl.mu.Lock()
+	hashes := MerkleInclusionProof(int(index), tl.leaves[:size])
+	tl.mu.Unlock()
+
+	return &InclusionProof{LeafIndex: index, Hashes: hashes, TreeHead: sth}, nil
+}
+
+// ConsistencyProof returns the proof that the tree of oldSize leaves is a
+// prefix of the tree of newSize leaves
+func (tl *TransparencyLog) ConsistencyProof(oldSize, newSize uint64) (*ConsistencyProof, error) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	if oldSize > newSize || newSize > uint64(len(tl.leaves)) {
+		return nil, ErrTreeSize
+	}
+
+	hashes := MerkleConsistencyProof(int(oldSize), tl.leaves[:newSize])
+	return &ConsistencyProof{OldSize: oldSize, NewSize: newSize, Hashes: hashes}, nil
+}
+
+// Publish appends a signed license to the log and embeds the proof of its
+// inclusion in the license
+func (tl *TransparencyLog) Publish(lic *LicenseData, pkey *rsa.PrivateKey) error {
+	lic.Proof = nil
+
+	index, err := tl.Append(lic)
+	if err != nil {
+		return err
+	}
+
+	proof, err := tl.InclusionProof(index, index+1, pkey)
+	if err != nil {
+		return err
+	}
+
+	lic.Proof = proof
+	return nil
+}
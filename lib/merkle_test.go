@@ -0,0 +1,100 @@
+package lib_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func testLeaves(n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		leaves[i] = lib.MerkleLeafHash([]byte{byte(i)})
+	}
+	return leaves
+}
+
+func TestMerkleInclusionProofs(t *testing.T) {
+	for n := 1; n <= 17; n++ {
+		leaves := testLeaves(n)
+		root := lib.MerkleTreeHash(leaves)
+		for m := 0; m < n; m++ {
+			proof := lib.MerkleInclusionProof(m, leaves)
+			if err := lib.VerifyMerkleInclusion(leaves[m], uint64(m), uint64(n), proof, root); err != nil {
+				t.Errorf("Inclusion proof of leaf %d in tree of size %d failed", m, n)
+			}
+			if n > 1 {
+				if err := lib.VerifyMerkleInclusion(leaves[(m+1)%n], uint64(m), uint64(n), proof, root); err == nil {
+					t.Errorf("Inclusion proof of wrong leaf %d in tree of size %d succeeded", m, n)
+				}
+			}
+		}
+	}
+}
+
+func TestMerkleConsistencyProofs(t *testing.T) {
+	for n := 1; n <= 17; n++ {
+		leaves := testLeaves(n)
+		root := lib.MerkleTreeHash(leaves)
+		for m := 1; m <= n; m++ {
+			oldRoot := lib.MerkleTreeHash(leaves[:m])
+			proof := lib.MerkleConsistencyProof(m, leaves)
+			if err := lib.VerifyMerkleConsistency(uint64(m), uint64(n), oldRoot, root, proof); err != nil {
+				t.Errorf("Consistency proof from %d to %d failed", m, n)
+			}
+			if m < n {
+				forged := lib.MerkleTreeHash(testLeaves(m + 1)[1:])
+				if err := lib.VerifyMerkleConsistency(uint64(m), uint64(n), forged, root, proof); err == nil {
+					t.Errorf("Consistency proof from forged tree %d to %d succeeded", m, n)
+				}
+			}
+		}
+	}
+}
+
+func TestTransparencyLog(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+
+	tl, err := lib.OpenTransparencyLog(filepath.Join(t.TempDir(), "transparency.log"))
+	if err != nil {
+		t.Fatal("Failed to open transparency log:", err)
+	}
+
+	var first *lib.LicenseData
+	for i := 0; i < 5; i++ {
+		lic := lib.NewLicense("Chathura Colombage", time.Now().Add(time.Hour))
+		if err := lic.Sign(pkey); err != nil {
+			t.Fatal("Failed to sign license:", err)
+		}
+		if err := tl.Publish(lic, pkey); err != nil {
+			t.Fatal("Failed to publish license:", err)
+		}
+		if err := lic.VerifyInclusion(&pkey.PublicKey); err != nil {
+			t.Error("License inclusion failed:", err)
+		}
+		if first == nil {
+			first = lic
+		}
+	}
+
+	first.Info.Name = "Someone Else"
+	if err := first.VerifyInclusion(&pkey.PublicKey); err != lib.ErrInvalidProof {
+		t.Error("Expected ErrInvalidProof for a modified license, but found", err)
+	}
+
+	older, _ := tl.TreeHead(2, pkey)
+	newer, _ := tl.TreeHead(5, pkey)
+	proof, err := tl.ConsistencyProof(2, 5)
+	if err != nil {
+		t.Fatal("Failed to get consistency proof:", err)
+	}
+	if err := lib.VerifyTreeHeads(older, newer, proof, &pkey.PublicKey); err != nil {
+		t.Error("Tree head consistency failed:", err)
+	}
+}
@@ -0,0 +1,266 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// Product key errors
+var (
+	ErrInvalidProductKey  = errors.New("Invalid product key")
+	ErrProductKeyFeatures = errors.New("Feature cannot be encoded in a product key")
+	ErrProductKeyExpiry   = errors.New("Expiry out of range for a product key")
+	ErrProductKeyID       = errors.New("Product key license IDs are 8 hex digits")
+	ErrNotEd25519Key      = errors.New("Not an Ed25519 key")
+)
+
+// Product key format. A product key is a compact binary license signed with
+// Ed25519, written in Crockford base32 in groups of five characters.
+//
+// The payload is 9 bytes: a version byte, a 32 bit serial number used as the
+// license ID, the expiry as a 16 bit day count since ProductKeyEpoch and a
+// 16 bit feature mask indexing a feature list shared by issuer and
+// application. Names, limits and metadata are not encoded.
+//
+// The 64 byte signature makes up most of the key. Ed25519 signatures cannot be
+// truncated without giving up verification with a public key, so keys are
+// 117 characters long in exchange for the full 128 bit security level.
+const (
+	productKeyVersion     = 1
+	productKeyPayloadSize = 9
+	productKeyGroupSize   = 5
+	productKeyMaxFeatures = 16
+	productKeySignContext = "license_gen product key v1\x00"
+)
+
+// ProductKeyEpoch is day zero of product key expiry dates
+var ProductKeyEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var crockford = base32.NewEncoding("0123456789ABCDEFGHJKMNPQRSTVWXYZ").WithPadding(base32.NoPadding)
+
+// NewProductKeyID returns a random license ID that fits in a product key
+func NewProductKeyID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// EncodeProductKey encodes the ID, expiry and features of info as a product
+// key signed with key. features is the ordered list of features the product
+// can grant, at most 16. The ID must come from NewProductKeyID.
+func EncodeProductKey(info *LicenseInfo, features []string, key ed25519.PrivateKey) (string, error) {
+	if len(features) > productKeyMaxFeatures {
+		return "", fmt.Errorf("%w: at most %d features are supported", ErrProductKeyFeatures, productKeyMaxFeatures)
+	}
+
+	serial, err := hex.DecodeString(info.ID)
+	if err != nil || len(serial) != 4 {
+		return "", ErrProductKeyID
+	}
+
+	days := info.Expiration.Sub(ProductKeyEpoch).Hours() / 24
+	if days < 0 || days > 0xffff {
+		return "", ErrProductKeyExpiry
+	}
+
+	var mask uint16
+	for _, f := range info.Features {
+		i := indexOf(features, f)
+		if i < 0 {
+			return "", fmt.Errorf("%w: %s", ErrProductKeyFeatures, f)
+		}
+		mask |= 1 << uint(i)
+	}
+
+	payload := make([]byte, productKeyPayloadSize, productKeyPayloadSize+ed25519.SignatureSize)
+	payload[0] = productKeyVersion
+	copy(payload[1:5], serial)
+	binary.BigEndian.PutUint16(payload[5:7], uint16(days))
+	binary.BigEndian.PutUint16(payload[7:9], mask)
+
+	sig := ed25519.Sign(key, append([]byte(productKeySignContext), payload...))
+
+	return groupProductKey(crockford.EncodeToString(append(payload, sig...))), nil
+}
+
+// DecodeProductKey verifies a product key with key and returns the license
+// information it holds. Dashes, spaces and case are ignored, and the letters
+// I, L and O are read as 1, 1 and 0 as Crockford base32 prescribes.
+func DecodeProductKey(productKey string, features []string, key ed25519.PublicKey) (*LicenseInfo, error) {
+	data, err := crockford.DecodeString(normalizeProductKey(productKey))
+	if err != nil || len(data) != productKeyPayloadSize+ed25519.SignatureSize {
+		return nil, ErrInvalidProductKey
+	}
+
+	payload, sig := data[:productKeyPayloadSize], data[productKeyPayloadSize:]
+	if payload[0] != productKeyVersion {
+		return nil, fmt.Errorf("%w: unknown version %d", ErrInvalidProductKey, payload[0])
+	}
+	if !ed25519.Verify(key, append([]byte(productKeySignContext), payload...), sig) {
+		return nil, InvalidLicense
+	}
+
+	days := binary.BigEndian.Uint16(payload[5:7])
+	mask := binary.BigEndian.Uint16(payload[7:9])
+
+	info := &LicenseInfo{
+		ID:         hex.EncodeToString(payload[1:5]),
+		Expiration: ProductKeyEpoch.AddDate(0, 0, int(days)),
+	}
+	for i := 0; i < productKeyMaxFeatures; i++ {
+		if mask&(1<<uint(i)) == 0 {
+			continue
+		}
+		if i >= len(features) {
+			return nil, fmt.Errorf("%w: unknown feature %d", ErrInvalidProductKey, i)
+		}
+		info.Features = append(info.Features, features[i])
+	}
+
+	return info, nil
+}
+
+func normalizeProductKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '-', ' ', '\t', '\n', '\r':
+			return -1
+		case 'i', 'I', 'l', 'L':
+			return '1'
+		case 'o', 'O':
+			return '0'
+		}
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		return r
+	}, key)
+}
+
+func groupProductKey(key string) string {
+	var groups []string
+	for len(key) > productKeyGroupSize {
+		groups = append(groups, key[:productKeyGroupSize])
+		key = key[productKeyGroupSize:]
+	}
+	return strings.Join(append(groups, key), "-")
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// GenerateProductKeyPair writes a new Ed25519 key pair for product keys to
+// the given PEM files
+func GenerateProductKeyPair(pubName, privName string) error {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return err
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(pubName, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(privName, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0600); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of a key file that is being overwritten
+	return os.Chmod(privName, 0600)
+}
+
+func readPEM(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrNotEd25519Key
+	}
+	return block.Bytes, nil
+}
+
+// ReadEd25519PublicKey reads a PEM encoded Ed25519 public key
+func ReadEd25519PublicKey(r io.Reader) (ed25519.PublicKey, error) {
+	der, err := readPEM(r)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, ErrNotEd25519Key
+	}
+	return pub, nil
+}
+
+// ReadEd25519PrivateKey reads a PEM encoded Ed25519 private key
+func ReadEd25519PrivateKey(r io.Reader) (ed25519.PrivateKey, error) {
+	der, err := readPEM(r)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, ErrNotEd25519Key
+	}
+	return priv, nil
+}
+
+func ReadEd25519PublicKeyFromFile(name string) (ed25519.PublicKey, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadEd25519PublicKey(file)
+}
+
+func ReadEd25519PrivateKeyFromFile(name string) (ed25519.PrivateKey, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadEd25519PrivateKey(file)
+}
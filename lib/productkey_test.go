@@ -0,0 +1,60 @@
+package lib_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func TestProductKey(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	features := []string{"sso", "audit", "reports"}
+
+	info := &lib.LicenseInfo{
+		ID:         lib.NewProductKeyID(),
+		Name:       "ACME",
+		Expiration: time.Date(2030, 1, 31, 0, 0, 0, 0, time.UTC),
+		Features:   []string{"reports", "sso"},
+	}
+
+	key, err := lib.EncodeProductKey(info, features, priv)
+	if err != nil {
+		t.Fatal("Failed to encode product key:", err)
+	}
+	if len(key) != 117+23 || strings.Count(key, "-") != 23 {
+		t.Error("Unexpected product key layout", key)
+	}
+
+	// keys read out over the phone come back in lower case with the letter O
+	typed := strings.Replace(strings.ToLower(key), "0", "o", -1)
+	decoded, err := lib.DecodeProductKey(typed, features, pub)
+	if err != nil {
+		t.Fatal("Failed to decode product key:", err)
+	}
+	if decoded.ID != info.ID || !decoded.Expiration.Equal(info.Expiration) ||
+		!decoded.HasFeature("sso") || !decoded.HasFeature("reports") || decoded.HasFeature("audit") {
+		t.Error("Expected", info, "but found", decoded)
+	}
+
+	tampered := []byte(key)
+	if tampered[3] == 'A' {
+		tampered[3] = 'B'
+	} else {
+		tampered[3] = 'A'
+	}
+	if _, err := lib.DecodeProductKey(string(tampered), features, pub); err != lib.InvalidLicense {
+		t.Error("Expected InvalidLicense, but found", err)
+	}
+
+	info.Features = []string{"unknown"}
+	if _, err := lib.EncodeProductKey(info, features, priv); err == nil {
+		t.Error("Expected an error for a feature outside the feature list")
+	}
+}
@@ -0,0 +1,144 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registry errors
+var (
+	// ErrLicenseNotFound is returned by a Registry when no license matches
+	ErrLicenseNotFound = errors.New("License not found in registry")
+	// ErrDuplicateID is returned by a Registry when a license with the same
+	// ID has already been recorded
+	ErrDuplicateID = errors.New("License ID already recorded in registry")
+)
+
+// IssuedLicense - A registry record of a license issued by us
+type IssuedLicense struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Expiration time.Time `json:"expiration"`
+	KeyID      string    `json:"key_id"`
+	Issuer     string    `json:"issuer"`
+	IssuedAt   time.Time `json:"issued_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	Payload    []byte    `json:"payload"`
+}
+
+// NewIssuedLicense builds a registry record for a signed license
+func NewIssuedLicense(lic *LicenseData, issuer string) (*IssuedLicense, error) {
+	var payload bytes.Buffer
+	if err := lic.WriteLicense(&payload); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	return &IssuedLicense{
+		ID:         lic.Info.ID,
+		Name:       lic.Info.Name,
+		Expiration: lic.Info.Expiration,
+		KeyID:      lic.KeyID,
+		Issuer:     issuer,
+		IssuedAt:   now,
+		UpdatedAt:  now,
+		Payload:    payload.Bytes(),
+	}, nil
+}
+
+// License decodes the stored license payload
+func (il *IssuedLicense) License() (*LicenseData, error) {
+	return ReadLicense(bytes.NewReader(il.Payload))
+}
+
+// Matches reports whether the record matches a free text search query. The
+// query is matched case-insensitively against the ID, name, key ID and issuer.
+func (il *IssuedLicense) Matches(query string) bool {
+	q := strings.ToLower(query)
+	for _, field := range []string{il.ID, il.Name, il.KeyID, il.Issuer} {
+		if strings.Contains(strings.ToLower(field), q) {
+			return true
+		}
+	}
+	return false
+}
+
+// Registry stores records of issued licenses
+type Registry interface {
+	// Record inserts a license. Records are never replaced: a license with an
+	// ID that has been recorded before is rejected with ErrDuplicateID.
+	Record(ctx context.Context, il *IssuedLicense) error
+	// Get returns the license with the given ID or ErrLicenseNotFound
+	Get(ctx context.Context, id string) (*IssuedLicense, error)
+	// List returns all licenses ordered by issue time
+	List(ctx context.Context) ([]*IssuedLicense, error)
+	// Search returns licenses matching query, ordered by issue time
+	Search(ctx context.Context, query string) ([]*IssuedLicense, error)
+	Close() error
+}
+
+// MemoryRegistry is a Registry kept in memory, mostly useful for tests
+type MemoryRegistry struct {
+	mu       sync.RWMutex
+	licenses map[string]*IssuedLicense
+}
+
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{licenses: make(map[string]*IssuedLicense)}
+}
+
+func (m *MemoryRegistry) Record(ctx context.Context, il *IssuedLicense) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.licenses[il.ID]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateID, il.ID)
+	}
+	rec := *il
+	m.licenses[il.ID] = &rec
+	return nil
+}
+
+func (m *MemoryRegistry) Get(ctx context.Context, id string) (*IssuedLicense, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	il, ok := m.licenses[id]
+	if !ok {
+		return nil, ErrLicenseNotFound
+	}
+	rec := *il
+	return &rec, nil
+}
+
+func (m *MemoryRegistry) List(ctx context.Context) ([]*IssuedLicense, error) {
+	return m.Search(ctx, "")
+}
+
+func (m *MemoryRegistry) Search(ctx context.Context, query string) ([]*IssuedLicense, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var res []*IssuedLicense
+	for _, il := range m.licenses {
+		if query == "" || il.Matches(query) {
+			rec := *il
+			res = append(res, &rec)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].IssuedAt.Before(res[j].IssuedAt)
+	})
+	return res, nil
+}
+
+func (m *MemoryRegistry) Close() error {
+	return nil
+}
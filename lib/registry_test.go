@@ -0,0 +1,65 @@
+package lib_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func TestMemoryRegistry(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+
+	lic := lib.NewLicense("Chathura Colombage", time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err := lic.Sign(pkey); err != nil {
+		t.Fatal("Failed to sign license:", err)
+	}
+
+	il, err := lib.NewIssuedLicense(lic, "ops")
+	if err != nil {
+		t.Fatal("Failed to create registry record:", err)
+	}
+
+	ctx := context.Background()
+	reg := lib.NewMemoryRegistry()
+	if err := reg.Record(ctx, il); err != nil {
+		t.Fatal("Failed to record license:", err)
+	}
+
+	if err := reg.Record(ctx, il); !errors.Is(err, lib.ErrDuplicateID) {
+		t.Error("Expected ErrDuplicateID, but found", err)
+	}
+
+	got, err := reg.Get(ctx, lic.Info.ID)
+	if err != nil {
+		t.Fatal("Failed to get license:", err)
+	}
+	if got.KeyID != lic.KeyID || got.Issuer != "ops" {
+		t.Error("Registry record does not match!")
+	}
+
+	stored, err := got.License()
+	if err != nil {
+		t.Fatal("Failed to decode stored license:", err)
+	}
+	if err := stored.ValidateLicenseKeyWithPublicKey(&pkey.PublicKey); err != nil {
+		t.Error("Stored license does not validate:", err)
+	}
+
+	if res, _ := reg.Search(ctx, "colombage"); len(res) != 1 {
+		t.Error("Expected 1 search result, but found", len(res))
+	}
+	if res, _ := reg.Search(ctx, "nobody"); len(res) != 0 {
+		t.Error("Expected no search results, but found", len(res))
+	}
+
+	if _, err := reg.Get(ctx, "missing"); err != lib.ErrLicenseNotFound {
+		t.Error("Expected ErrLicenseNotFound, but found", err)
+	}
+}
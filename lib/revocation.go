@@ -0,0 +1,288 @@
+package lib
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Revocation errors
+var (
+	ErrLicenseRevoked          = errors.New("License revoked")
+	ErrInvalidRevocationList   = errors.New("Invalid revocation list")
+	ErrRevocationListRollback  = errors.New("Revocation list is older than the last seen list")
+	ErrRevocationEntryRequired = errors.New("Revocation requires a license ID or key ID")
+)
+
+// Common revocation reasons
+const (
+	ReasonUnspecified   = "unspecified"
+	ReasonChargeback    = "chargeback"
+	ReasonKeyCompromise = "key-compromise"
+	ReasonSuperseded    = "superseded"
+	ReasonCancelled     = "cancelled"
+)
+
+// Revocation - A single revoked license or signing key
+type Revocation struct {
+	LicenseID string    `json:"license_id,omitempty"`
+	KeyID     string    `json:"key_id,omitempty"`
+	Reason    string    `json:"reason"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+// RevocationInfo - The signed part of a revocation list. Sequence increases
+// every time the list is signed and is used to detect rollback.
+type RevocationInfo struct {
+	Sequence    uint64       `json:"sequence"`
+	Issued      time.Time    `json:"issued"`
+	Revocations []Revocation `json:"revocations"`
+}
+
+// RevocationList - This is the revocation data we serialise into a file
+type RevocationList struct {
+	Info  RevocationInfo `json:"info"`
+	KeyID string         `json:"key_id,omitempty"`
+	Key   string         `json:"key"`
+}
+
+// NewRevocationList returns an empty, unsigned revocation list
+func NewRevocationList() *RevocationList {
+	return &RevocationList{Info: RevocationInfo{Revocations: []Revocation{}}}
+}
+
+// Revoke adds r to the list. The list must be signed again afterwards.
+func (rl *RevocationList) Revoke(r Revocation) error {
+	if r.LicenseID == "" && r.KeyID == "" {
+		return ErrRevocationEntryRequired
+	}
+	if r.Reason == "" {
+		r.Reason = ReasonUnspecified
+	}
+	if r.RevokedAt.IsZero() {
+		r.RevokedAt = time.Now().UTC()
+	}
+
+	rl.Info.Revocations = append(rl.Info.Revocations, r)
+	return nil
+}
+
+// Lookup returns the revocation entry matching the license ID of lic or
+// keyID, if any. keyID must be the ID of the key the signature of lic was
+// verified with: the key_id field of a license is not signed, so it can be
+// removed or changed to dodge a key revocation.
+func (rl *RevocationList) Lookup(lic *LicenseData, keyID string) (*Revocation, bool) {
+	for i := range rl.Info.Revocations {
+		r := &rl.Info.Revocations[i]
+		if r.LicenseID != "" && r.LicenseID == lic.Info.ID {
+			return r, true
+		}
+		if r.KeyID != "" && r.KeyID == keyID {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// Check returns ErrLicenseRevoked if lic or keyID appears in the list. See
+// Lookup for keyID.
+func (rl *RevocationList) Check(lic *LicenseData, keyID string) error {
+	if r, ok := rl.Lookup(lic, keyID); ok {
+		return fmt.Errorf("%w: %s (%s)", ErrLicenseRevoked, r.Reason, r.RevokedAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// CheckSequence returns ErrRevocationListRollback if the list is older than
+// minSequence
+func (rl *RevocationList) CheckSequence(minSequence uint64) error {
+	if rl.Info.Sequence < minSequence {
+		return ErrRevocationListRollback
+	}
+	return nil
+}
+
+// Sign bumps the sequence number and issue time and signs the list with the
+// given RSA private key
+func (rl *RevocationList) Sign(pkey *rsa.PrivateKey) error {
+	rl.Info.Sequence++
+	rl.Info.Issued = time.Now().UTC()
+
+	jsonInfo, err := json.Marshal(rl.Info)
+	if err != nil {
+		return err
+	}
+
+	signedData, err := Sign(pkey, jsonInfo)
+	if err != nil {
+		return err
+	}
+
+	keyID, err := KeyID(&pkey.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	rl.Key = encodeKey(signedData)
+	rl.KeyID = keyID
+
+	return nil
+}
+
+// Verify checks the list signature against the given public key
+func (rl *RevocationList) Verify(publicKey *rsa.PublicKey) error {
+	signedData, err := decodeKey(rl.Key)
+	if err != nil {
+		return ErrInvalidRevocationList
+	}
+
+	jsonInfo, err := json.Marshal(rl.Info)
+	if err != nil {
+		return err
+	}
+
+	if err := Unsign(publicKey, jsonInfo, signedData); err != nil {
+		return ErrInvalidRevocationList
+	}
+
+	return nil
+}
+
+func (rl *RevocationList) WriteRevocationList(w io.Writer) error {
+	jsonList, err := json.MarshalIndent(rl, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, werr := fmt.Fprintf(w, "%s", string(jsonList))
+	return werr
+}
+
+func (rl *RevocationList) SaveRevocationListToFile(fileName string) error {
+	jsonList, err := json.MarshalIndent(rl, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(fileName, jsonList, 0644)
+}
+
+func ReadRevocationList(r io.Reader) (*RevocationList, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var rl RevocationList
+	if err := json.Unmarshal(data, &rl); err != nil {
+		return nil, err
+	}
+
+	return &rl, nil
+}
+
+func ReadRevocationListFromFile(fileName string) (*RevocationList, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadRevocationList(file)
+}
+
+// ReadRevocationSequence returns the revocation list sequence recorded in a
+// state file by SaveRevocationSequence, 0 when the file does not exist
+func ReadRevocationSequence(fileName string) (uint64, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	seq, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid revocation list sequence: %w", fileName, err)
+	}
+	return seq, nil
+}
+
+// SaveRevocationSequence records seq in a state file. The file is replaced
+// atomically so that a crash cannot leave it empty.
+func SaveRevocationSequence(fileName string, seq uint64) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(strconv.FormatUint(seq, 10) + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fileName)
+}
+
+// DefaultRevocationRefresh is how long a Verifier uses a revocation list
+// loaded by WithRevocationListFrom before loading it again
+const DefaultRevocationRefresh = 5 * time.Minute
+
+// revocationCache - The revocation list a Verifier loaded from its source and
+// the highest list sequence it accepted, shared by concurrent checks
+type revocationCache struct {
+	mu        sync.Mutex
+	list      *RevocationList
+	loaded    time.Time
+	seen      uint64
+	stateRead bool
+}
+
+// revocationClient fetches revocation lists. The timeout bounds requests
+// whose context has no deadline.
+var revocationClient = &http.Client{Timeout: 30 * time.Second}
+
+// LoadRevocationList reads a revocation list from src, which is either an
+// http(s) URL or a file path. The signature is not verified here.
+func LoadRevocationList(src string) (*RevocationList, error) {
+	return LoadRevocationListContext(context.Background(), src)
+}
+
+// LoadRevocationListContext is LoadRevocationList with a context for the
+// http(s) request
+func LoadRevocationListContext(ctx context.Context, src string) (*RevocationList, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return ReadRevocationListFromFile(src)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := revocationClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Fetching revocation list from %s failed: %s", src, resp.Status)
+	}
+
+	return ReadRevocationList(resp.Body)
+}
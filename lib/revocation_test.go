@@ -0,0 +1,290 @@
+package lib_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func TestRevocationList(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+
+	lic := lib.NewLicense("Chathura Colombage", time.Now().Add(24*time.Hour))
+	if err := lic.Sign(pkey); err != nil {
+		t.Fatal("Failed to sign license:", err)
+	}
+
+	var licBuf bytes.Buffer
+	lic.WriteLicense(&licBuf)
+
+	rl := lib.NewRevocationList()
+	if err := rl.Sign(pkey); err != nil {
+		t.Fatal("Failed to sign revocation list:", err)
+	}
+
+	err = lib.CheckLicense(bytes.NewReader(licBuf.Bytes()), strings.NewReader(pubKey), lib.WithRevocationList(rl))
+	if err != nil {
+		t.Error("Expected nil error, but found", err)
+	}
+
+	if err := rl.Revoke(lib.Revocation{LicenseID: lic.Info.ID, Reason: lib.ReasonChargeback}); err != nil {
+		t.Fatal("Failed to revoke license:", err)
+	}
+	if err := rl.Sign(pkey); err != nil {
+		t.Fatal("Failed to sign revocation list:", err)
+	}
+
+	if rl.Info.Sequence != 2 {
+		t.Error("Expected sequence 2, but found", rl.Info.Sequence)
+	}
+
+	err = lib.CheckLicense(bytes.NewReader(licBuf.Bytes()), strings.NewReader(pubKey), lib.WithRevocationList(rl))
+	if !errors.Is(err, lib.ErrLicenseRevoked) {
+		t.Error("Expected ErrLicenseRevoked, but found", err)
+	}
+
+	err = lib.CheckLicense(bytes.NewReader(licBuf.Bytes()), strings.NewReader(pubKey),
+		lib.WithRevocationList(rl), lib.WithMinRevocationSequence(3))
+	if !errors.Is(err, lib.ErrRevocationListRollback) {
+		t.Error("Expected ErrRevocationListRollback, but found", err)
+	}
+
+	rl.Info.Revocations = nil
+	if err := rl.Verify(&pkey.PublicKey); err != lib.ErrInvalidRevocationList {
+		t.Error("Expected tampered list to fail verification, but found", err)
+	}
+}
+
+func TestRevocationListKeyID(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+
+	lic := lib.NewLicense("Chathura Colombage", time.Now().Add(24*time.Hour))
+	if err := lic.Sign(pkey); err != nil {
+		t.Fatal("Failed to sign license:", err)
+	}
+
+	rl := lib.NewRevocationList()
+	if err := rl.Revoke(lib.Revocation{KeyID: lic.KeyID, Reason: lib.ReasonKeyCompromise}); err != nil {
+		t.Fatal("Failed to revoke key:", err)
+	}
+	if err := rl.Sign(pkey); err != nil {
+		t.Fatal("Failed to sign revocation list:", err)
+	}
+
+	// key_id is not signed, removing it must not dodge the key revocation
+	for _, keyID := range []string{lic.KeyID, "", "0000000000000000"} {
+		lic.KeyID = keyID
+		var licBuf bytes.Buffer
+		lic.WriteLicense(&licBuf)
+
+		err = lib.CheckLicense(bytes.NewReader(licBuf.Bytes()), strings.NewReader(pubKey), lib.WithRevocationList(rl))
+		if keyID == "0000000000000000" {
+			// an unknown key ID matches no trusted key
+			if err == nil {
+				t.Error("Expected license with unknown key_id to fail")
+			}
+			continue
+		}
+		if !errors.Is(err, lib.ErrLicenseRevoked) {
+			t.Errorf("Expected ErrLicenseRevoked with key_id %q, but found %v", keyID, err)
+		}
+	}
+}
+
+func TestRevocationStateFile(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+
+	lic := lib.NewLicense("Chathura Colombage", time.Now().Add(24*time.Hour))
+	if err := lic.Sign(pkey); err != nil {
+		t.Fatal("Failed to sign license:", err)
+	}
+	var licBuf bytes.Buffer
+	lic.WriteLicense(&licBuf)
+
+	rl := lib.NewRevocationList()
+	rl.Sign(pkey)
+	rl.Sign(pkey)
+	old := *rl
+	rl.Sign(pkey)
+
+	state := filepath.Join(t.TempDir(), "crl.seq")
+	check := func(rl *lib.RevocationList) error {
+		return lib.CheckLicense(bytes.NewReader(licBuf.Bytes()), strings.NewReader(pubKey),
+			lib.WithRevocationList(rl), lib.WithRevocationStateFile(state))
+	}
+
+	if err := check(rl); err != nil {
+		t.Fatal("Expected nil error, but found", err)
+	}
+	if seq, err := lib.ReadRevocationSequence(state); err != nil || seq != 3 {
+		t.Errorf("Expected sequence 3 recorded, but found %d (%v)", seq, err)
+	}
+
+	if err := check(&old); !errors.Is(err, lib.ErrRevocationListRollback) {
+		t.Error("Expected ErrRevocationListRollback, but found", err)
+	}
+}
+
+func TestRevocationListKeyRotation(t *testing.T) {
+	oldKey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal("Failed to generate key:", err)
+	}
+
+	oldLic := lib.NewLicense("Chathura Colombage", time.Now().Add(24*time.Hour))
+	if err := oldLic.Sign(oldKey); err != nil {
+		t.Fatal("Failed to sign license:", err)
+	}
+	newLic := lib.NewLicense("Chathura Colombage", time.Now().Add(24*time.Hour))
+	if err := newLic.Sign(newKey); err != nil {
+		t.Fatal("Failed to sign license:", err)
+	}
+
+	// the old key is compromised, the list revoking it is signed with the new
+	// key and applies to licenses of both keys
+	rl := lib.NewRevocationList()
+	if err := rl.Revoke(lib.Revocation{KeyID: oldLic.KeyID, Reason: lib.ReasonKeyCompromise}); err != nil {
+		t.Fatal("Failed to revoke key:", err)
+	}
+	if err := rl.Sign(newKey); err != nil {
+		t.Fatal("Failed to sign revocation list:", err)
+	}
+
+	ts, err := lib.NewTrustStore(&oldKey.PublicKey, &newKey.PublicKey)
+	if err != nil {
+		t.Fatal("Failed to create trust store:", err)
+	}
+	v := lib.NewVerifier(lib.WithTrustStore(ts), lib.WithRevocationList(rl))
+
+	ctx := context.Background()
+	if _, err := v.VerifyLicense(ctx, oldLic); !errors.Is(err, lib.ErrLicenseRevoked) {
+		t.Error("Expected ErrLicenseRevoked for the old key, but found", err)
+	}
+	if _, err := v.VerifyLicense(ctx, newLic); err != nil {
+		t.Error("Expected nil error for the new key, but found", err)
+	}
+
+	// with a revocation key, lists signed by the compromised key are rejected
+	forged := lib.NewRevocationList()
+	forged.Info.Sequence = rl.Info.Sequence
+	if err := forged.Sign(oldKey); err != nil {
+		t.Fatal("Failed to sign revocation list:", err)
+	}
+	v = lib.NewVerifier(lib.WithTrustStore(ts), lib.WithRevocationList(forged), lib.WithRevocationKey(&newKey.PublicKey))
+	if _, err := v.VerifyLicense(ctx, oldLic); !errors.Is(err, lib.ErrInvalidRevocationList) {
+		t.Error("Expected ErrInvalidRevocationList for a list signed by the old key, but found", err)
+	}
+}
+
+func TestRevocationListFromCache(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+
+	lic := lib.NewLicense("Chathura Colombage", time.Now().Add(24*time.Hour))
+	if err := lic.Sign(pkey); err != nil {
+		t.Fatal("Failed to sign license:", err)
+	}
+
+	rl := lib.NewRevocationList()
+	if err := rl.Sign(pkey); err != nil {
+		t.Fatal("Failed to sign revocation list:", err)
+	}
+
+	var mu sync.Mutex
+	fetches, failing := 0, false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		if failing {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		rl.WriteRevocationList(w)
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	var clockMu sync.Mutex
+	clock := func() time.Time {
+		clockMu.Lock()
+		defer clockMu.Unlock()
+		return now
+	}
+	state := filepath.Join(t.TempDir(), "crl.seq")
+	v := lib.NewVerifier(lib.WithPublicKey(&pkey.PublicKey), lib.WithClock(clock),
+		lib.WithRevocationListFrom(srv.URL), lib.WithRevocationRefresh(time.Minute),
+		lib.WithRevocationStateFile(state))
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := v.VerifyLicense(ctx, lic); err != nil {
+				t.Error("Expected nil error, but found", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if fetches != 1 {
+		t.Errorf("Expected the list to be fetched once, but found %d fetches", fetches)
+	}
+	if seq, err := lib.ReadRevocationSequence(state); err != nil || seq != 1 {
+		t.Errorf("Expected sequence 1 recorded, but found %d (%v)", seq, err)
+	}
+
+	// once the list is out of date it is fetched again
+	mu.Lock()
+	rl.Revoke(lib.Revocation{LicenseID: lic.Info.ID})
+	rl.Sign(pkey)
+	mu.Unlock()
+	clockMu.Lock()
+	now = now.Add(2 * time.Minute)
+	clockMu.Unlock()
+	if _, err := v.VerifyLicense(ctx, lic); !errors.Is(err, lib.ErrLicenseRevoked) {
+		t.Error("Expected ErrLicenseRevoked after refresh, but found", err)
+	}
+
+	// a failed refresh keeps the last list
+	mu.Lock()
+	failing = true
+	mu.Unlock()
+	clockMu.Lock()
+	now = now.Add(2 * time.Minute)
+	clockMu.Unlock()
+	if _, err := v.VerifyLicense(ctx, lic); !errors.Is(err, lib.ErrLicenseRevoked) {
+		t.Error("Expected ErrLicenseRevoked from the cached list, but found", err)
+	}
+	if fetches != 3 {
+		t.Errorf("Expected 3 fetches, but found %d", fetches)
+	}
+}
@@ -0,0 +1,162 @@
+// Package sqlitereg implements lib.Registry on top of SQLite using a pure Go
+// driver, so lgen does not need cgo.
+package sqlitereg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+
+	_ "modernc.org/sqlite"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS licenses (
+	id         TEXT PRIMARY KEY,
+	name       TEXT NOT NULL,
+	expiration TEXT NOT NULL,
+	key_id     TEXT NOT NULL,
+	issuer     TEXT NOT NULL,
+	issued_at  TEXT NOT NULL,
+	updated_at TEXT NOT NULL,
+	payload    BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS licenses_name ON licenses(name);
+`
+
+const columns = `id, name, expiration, key_id, issuer, issued_at, updated_at, payload`
+
+// Registry is a lib.Registry stored in an SQLite database file
+type Registry struct {
+	db *sql.DB
+}
+
+var _ lib.Registry = (*Registry)(nil)
+
+// busyTimeout is how long a write waits for another process writing to the
+// same database, such as concurrent lgen runs
+const busyTimeout = 10 * time.Second
+
+// Open opens (creating if needed) the registry database at path
+func Open(path string) (*Registry, error) {
+	db, err := sql.Open("sqlite", fmt.Sprintf("%s?_pragma=busy_timeout(%d)", path, busyTimeout.Milliseconds()))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Registry{db: db}, nil
+}
+
+func (r *Registry) Record(ctx context.Context, il *lib.IssuedLicense) error {
+	res, err := r.db.ExecContext(ctx, `
+		INSERT INTO licenses (`+columns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO NOTHING`,
+		il.ID, il.Name, formatTime(il.Expiration), il.KeyID, il.Issuer,
+		formatTime(il.IssuedAt), formatTime(il.UpdatedAt), il.Payload)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s", lib.ErrDuplicateID, il.ID)
+	}
+	return nil
+}
+
+func (r *Registry) Get(ctx context.Context, id string) (*lib.IssuedLicense, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+columns+` FROM licenses WHERE id = ?`, id)
+
+	il, err := scan(row)
+	if err == sql.ErrNoRows {
+		return nil, lib.ErrLicenseNotFound
+	}
+	return il, err
+}
+
+func (r *Registry) List(ctx context.Context) ([]*lib.IssuedLicense, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+columns+` FROM licenses ORDER BY issued_at`)
+	if err != nil {
+		return nil, err
+	}
+	return scanAll(rows)
+}
+
+func (r *Registry) Search(ctx context.Context, query string) ([]*lib.IssuedLicense, error) {
+	pattern := "%" + query + "%"
+	rows, err := r.db.QueryContext(ctx, `SELECT `+columns+` FROM licenses
+		WHERE id LIKE ? OR name LIKE ? OR key_id LIKE ? OR issuer LIKE ?
+		ORDER BY issued_at`, pattern, pattern, pattern, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return scanAll(rows)
+}
+
+func (r *Registry) Close() error {
+	return r.db.Close()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scan(s scanner) (*lib.IssuedLicense, error) {
+	var il lib.IssuedLicense
+	var expiration, issuedAt, updatedAt string
+
+	err := s.Scan(&il.ID, &il.Name, &expiration, &il.KeyID, &il.Issuer, &issuedAt, &updatedAt, &il.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if il.Expiration, err = parseTime(expiration); err != nil {
+		return nil, err
+	}
+	if il.IssuedAt, err = parseTime(issuedAt); err != nil {
+		return nil, err
+	}
+	if il.UpdatedAt, err = parseTime(updatedAt); err != nil {
+		return nil, err
+	}
+
+	return &il, nil
+}
+
+func scanAll(rows *sql.Rows) ([]*lib.IssuedLicense, error) {
+	defer rows.Close()
+
+	var res []*lib.IssuedLicense
+	for rows.Next() {
+		il, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, il)
+	}
+
+	return res, rows.Err()
+}
+
+// Times are stored as RFC 3339 text with fixed width fractions so that they
+// sort correctly in SQL
+const timeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+func parseTime(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, s)
+}
@@ -0,0 +1,62 @@
+package sqlitereg_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+	"github.com/dewaka/license_gen/lib/sqlitereg"
+)
+
+func TestRegistry(t *testing.T) {
+	reg, err := sqlitereg.Open(filepath.Join(t.TempDir(), "licenses.db"))
+	if err != nil {
+		t.Fatal("Failed to open registry:", err)
+	}
+	defer reg.Close()
+
+	ctx := context.Background()
+	issued := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	il := &lib.IssuedLicense{
+		ID:         "abc123",
+		Name:       "Chathura Colombage",
+		Expiration: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		KeyID:      "0011223344556677",
+		Issuer:     "ops",
+		IssuedAt:   issued,
+		UpdatedAt:  issued,
+		Payload:    []byte(`{"info":{}}`),
+	}
+
+	if err := reg.Record(ctx, il); err != nil {
+		t.Fatal("Failed to record license:", err)
+	}
+
+	// a reused ID must not replace the recorded license
+	reused := *il
+	reused.Name = "Someone Else"
+	reused.IssuedAt = issued.Add(time.Hour)
+	reused.Payload = []byte(`{"info":{"name":"Someone Else"}}`)
+	if err := reg.Record(ctx, &reused); !errors.Is(err, lib.ErrDuplicateID) {
+		t.Error("Expected ErrDuplicateID, but found", err)
+	}
+
+	got, err := reg.Get(ctx, "abc123")
+	if err != nil {
+		t.Fatal("Failed to get license:", err)
+	}
+	if got.Name != il.Name || !got.IssuedAt.Equal(issued) || string(got.Payload) != string(il.Payload) {
+		t.Error("Recorded license was replaced, found", got)
+	}
+
+	if res, err := reg.Search(ctx, "Colombage"); err != nil || len(res) != 1 {
+		t.Error("Expected 1 search result, but found", len(res), err)
+	}
+
+	if _, err := reg.Get(ctx, "missing"); err != lib.ErrLicenseNotFound {
+		t.Error("Expected ErrLicenseNotFound, but found", err)
+	}
+}
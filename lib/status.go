@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"math"
+	"time"
+)
+
+// Algorithm names of the supported license signatures
+const (
+	AlgRS256 = "RS256" // RSA PKCS #1 v1.5 with SHA-256
+	AlgEdDSA = "EdDSA" // Ed25519, used by COSE licenses
+)
+
+// Computed license states
+const (
+	StatusValid       = "valid"
+	StatusNotYetValid = "not-yet-valid"
+	StatusGrace       = "grace"
+	StatusExpired     = "expired"
+)
+
+// LicenseStatus - The state of a license at a point in time. It is computed
+// from the license information only and says nothing about the signature.
+type LicenseStatus struct {
+	Status     string     `json:"status"`
+	DaysLeft   int        `json:"days_left"`
+	Expiration time.Time  `json:"expiration"`
+	NotBefore  *time.Time `json:"not_before,omitempty"`
+	GraceUntil *time.Time `json:"grace_until,omitempty"`
+}
+
+// Algorithm returns the signature algorithm of the license
+func (lic *LicenseData) Algorithm() string {
+	if lic.cose != nil {
+		return AlgEdDSA
+	}
+	return AlgRS256
+}
+
+// Status computes the license state at now. An expired license is in its
+// grace period for grace after its expiry.
+func (lic *LicenseData) Status(now time.Time, grace time.Duration) LicenseStatus {
+	st := LicenseStatus{
+		Status:     StatusValid,
+		DaysLeft:   int(math.Floor(lic.Info.Expiration.Sub(now).Hours() / 24)),
+		Expiration: lic.Info.Expiration,
+		NotBefore:  lic.Info.NotBefore,
+	}
+
+	if grace > 0 {
+		until := lic.Info.Expiration.Add(grace)
+		st.GraceUntil = &until
+	}
+
+	switch {
+	case lic.Info.NotBefore != nil && now.Before(*lic.Info.NotBefore):
+		st.Status = StatusNotYetValid
+	case !now.After(lic.Info.Expiration):
+		st.Status = StatusValid
+	case st.GraceUntil != nil && !now.After(*st.GraceUntil):
+		st.Status = StatusGrace
+	default:
+		st.Status = StatusExpired
+	}
+
+	return st
+}
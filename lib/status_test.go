@@ -0,0 +1,39 @@
+package lib_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func TestLicenseStatus(t *testing.T) {
+	expiry := time.Date(2030, 1, 31, 0, 0, 0, 0, time.UTC)
+	notBefore := time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	lic := lib.NewLicense("Chathura Colombage", expiry)
+	lic.Info.NotBefore = &notBefore
+
+	tests := []struct {
+		now      time.Time
+		status   string
+		daysLeft int
+	}{
+		{time.Date(2028, 12, 31, 0, 0, 0, 0, time.UTC), lib.StatusNotYetValid, 396},
+		{time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), lib.StatusValid, 30},
+		{time.Date(2030, 2, 5, 0, 0, 0, 0, time.UTC), lib.StatusGrace, -5},
+		{time.Date(2030, 3, 1, 0, 0, 0, 0, time.UTC), lib.StatusExpired, -29},
+	}
+
+	for _, tc := range tests {
+		st := lic.Status(tc.now, 7*24*time.Hour)
+		if st.Status != tc.status || st.DaysLeft != tc.daysLeft {
+			t.Errorf("At %s expected %s with %d days left, but found %s with %d", tc.now.Format("2006-01-02"),
+				tc.status, tc.daysLeft, st.Status, st.DaysLeft)
+		}
+	}
+
+	if st := lic.Status(time.Date(2030, 2, 5, 0, 0, 0, 0, time.UTC), 0); st.Status != lib.StatusExpired {
+		t.Error("Expected expired without a grace period, but found", st.Status)
+	}
+}
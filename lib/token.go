@@ -0,0 +1,410 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Token errors
+var (
+	ErrInvalidToken = errors.New("Invalid license token")
+	ErrTokenExpired = errors.New("License token expired")
+	ErrNoToken      = errors.New("No license token available")
+)
+
+// DefaultTokenTTL is the lifetime of tokens issued by a TokenHandler when no
+// TTL is configured
+const DefaultTokenTTL = 24 * time.Hour
+
+// TokenInfo - Claims carried by a short-lived license token
+type TokenInfo struct {
+	LicenseID  string    `json:"license_id"`
+	Name       string    `json:"name"`
+	IssuedAt   time.Time `json:"issued_at"`
+	Expiration time.Time `json:"expiration"`
+}
+
+// Token - A short-lived signed token exchanged for a long-lived license
+type Token struct {
+	Info  TokenInfo `json:"info"`
+	KeyID string    `json:"key_id,omitempty"`
+	Key   string    `json:"key"`
+}
+
+// IssueToken creates a token for lic valid for ttl, signed with pkey. The
+// token never outlives the license itself. The license is not verified here.
+func IssueToken(lic *LicenseData, pkey *rsa.PrivateKey, ttl time.Duration) (*Token, error) {
+	now := time.Now().UTC()
+	expiry := now.Add(ttl)
+	if lic.Info.Expiration.Before(expiry) {
+		expiry = lic.Info.Expiration
+	}
+
+	tok := &Token{Info: TokenInfo{
+		LicenseID:  lic.Info.ID,
+		Name:       lic.Info.Name,
+		IssuedAt:   now,
+		Expiration: expiry,
+	}}
+
+	jsonInfo, err := json.Marshal(tok.Info)
+	if err != nil {
+		return nil, err
+	}
+
+	signedData, err := Sign(pkey, jsonInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, err := KeyID(&pkey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	tok.Key = encodeKey(signedData)
+	tok.KeyID = keyID
+
+	return tok, nil
+}
+
+// Verify checks the token signature against the given public key
+func (tok *Token) Verify(publicKey *rsa.PublicKey) error {
+	signedData, err := decodeKey(tok.Key)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	jsonInfo, err := json.Marshal(tok.Info)
+	if err != nil {
+		return err
+	}
+
+	if err := Unsign(publicKey, jsonInfo, signedData); err != nil {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+// CheckExpiry returns ErrTokenExpired if the token is expired at now
+func (tok *Token) CheckExpiry(now time.Time) error {
+	if now.After(tok.Info.Expiration) {
+		return ErrTokenExpired
+	}
+	return nil
+}
+
+func ReadToken(r io.Reader) (*Token, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var tok Token
+	if err := json.Unmarshal(data, &tok); err != nil {
+		return nil, err
+	}
+
+	return &tok, nil
+}
+
+func ReadTokenFromFile(fileName string) (*Token, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadToken(file)
+}
+
+func (tok *Token) SaveTokenToFile(fileName string) error {
+	jsonTok, err := json.MarshalIndent(tok, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(fileName, jsonTok, 0600)
+}
+
+// TokenHandler is the server side of the token exchange. It accepts a POSTed
+// license, checks it and responds with a freshly signed token.
+type TokenHandler struct {
+	PublicKey  *rsa.PublicKey
+	PrivateKey *rsa.PrivateKey
+	TTL        time.Duration
+	Options    []VerifyOption
+}
+
+func (h *TokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	lic, err := ReadLicense(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, ErrorLicenseRead.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := append([]VerifyOption{WithPublicKey(h.PublicKey)}, h.Options...)
+	if err := NewVerifier(opts...).Validate(r.Context(), lic).Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	ttl := h.TTL
+	if ttl == 0 {
+		ttl = DefaultTokenTTL
+	}
+
+	tok, err := IssueToken(lic, h.PrivateKey, ttl)
+	if err != nil {
+		http.Error(w, "Token signing failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tok)
+}
+
+// TokenClient exchanges a license for tokens from a license server and keeps
+// the latest token cached on disk, so that the application can keep running
+// offline until the cached token expires.
+type TokenClient struct {
+	// URL of the license server token endpoint
+	URL string
+	// License to exchange for tokens
+	License *LicenseData
+	// PublicKey used to verify tokens returned by the server
+	PublicKey *rsa.PublicKey
+	// CacheFile holds the last token received. Optional.
+	CacheFile string
+	// RefreshBefore is how long before token expiry a refresh is attempted.
+	// Defaults to a quarter of the token lifetime.
+	RefreshBefore time.Duration
+	// RetryInterval is the wait between failed refresh attempts. Defaults to
+	// one minute.
+	RetryInterval time.Duration
+	// HTTPClient used for requests. Defaults to a client with a 30s timeout.
+	HTTPClient *http.Client
+
+	mu    sync.RWMutex
+	token *Token
+}
+
+// LoadCache reads and verifies the cached token, if any. An expired cached
+// token is kept so the next refresh can replace it.
+func (c *TokenClient) LoadCache() error {
+	if c.CacheFile == "" {
+		return nil
+	}
+
+	tok, err := ReadTokenFromFile(c.CacheFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if err := c.checkToken(tok); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.token = tok
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *TokenClient) checkToken(tok *Token) error {
+	if err := tok.Verify(c.PublicKey); err != nil {
+		return err
+	}
+	if c.License != nil && tok.Info.LicenseID != c.License.Info.ID {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// Token returns the current token, or an error if there is no token or it
+// has expired
+func (c *TokenClient) Token() (*Token, error) {
+	c.mu.RLock()
+	tok := c.token
+	c.mu.RUnlock()
+
+	if tok == nil {
+		return nil, ErrNoToken
+	}
+	if err := tok.CheckExpiry(time.Now()); err != nil {
+		return nil, err
+	}
+
+	return tok, nil
+}
+
+// Valid reports whether the application may run, i.e. whether a current
+// token is held
+func (c *TokenClient) Valid() error {
+	_, err := c.Token()
+	return err
+}
+
+// Refresh exchanges the license for a new token and updates the cache file
+func (c *TokenClient) Refresh(ctx context.Context) (*Token, error) {
+	body, contentType, err := licenseBody(c.License)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", contentType)
+
+	client := c.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("Token refresh failed: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	tok, err := ReadToken(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.checkToken(tok); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.token = tok
+	c.mu.Unlock()
+
+	if c.CacheFile != "" {
+		if err := tok.SaveTokenToFile(c.CacheFile); err != nil {
+			return tok, err
+		}
+	}
+
+	return tok, nil
+}
+
+// licenseBody returns the license to send to the token server. JWT and COSE
+// licenses are sent as the token or message they were read from, which holds
+// their signature; other licenses are sent as JSON.
+func licenseBody(lic *LicenseData) ([]byte, string, error) {
+	switch {
+	case lic.jwt != "":
+		return []byte(lic.jwt), "application/jwt", nil
+	case lic.cose != nil:
+		return lic.cose, "application/cose", nil
+	}
+
+	var body bytes.Buffer
+	if err := lic.WriteLicense(&body); err != nil {
+		return nil, "", err
+	}
+	return body.Bytes(), "application/json", nil
+}
+
+// nextRefresh returns how long to wait before the next refresh attempt
+func (c *TokenClient) nextRefresh(now time.Time) time.Duration {
+	c.mu.RLock()
+	tok := c.token
+	c.mu.RUnlock()
+
+	if tok == nil {
+		return 0
+	}
+
+	before := c.RefreshBefore
+	if before == 0 {
+		before = tok.Info.Expiration.Sub(tok.Info.IssuedAt) / 4
+	}
+
+	wait := tok.Info.Expiration.Add(-before).Sub(now)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// Run keeps the token fresh until ctx is cancelled. Refresh errors are passed
+// to onError (if not nil) and retried; the cached token stays usable until its
+// own expiry in the meantime.
+func (c *TokenClient) Run(ctx context.Context, onError func(error)) {
+	retry := c.RetryInterval
+	if retry == 0 {
+		retry = time.Minute
+	}
+
+	refreshed := false
+	for {
+		wait := c.nextRefresh(time.Now())
+		if refreshed && wait < retry {
+			// the server handed out a token that is already due for refresh
+			wait = retry
+		}
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		_, err := c.Refresh(ctx)
+		refreshed = err == nil
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if onError != nil {
+				onError(err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retry):
+			}
+		}
+	}
+}
+
+// Start loads the cached token and runs the refresh loop in the background
+func (c *TokenClient) Start(ctx context.Context, onError func(error)) {
+	if err := c.LoadCache(); err != nil && onError != nil {
+		onError(err)
+	}
+
+	go c.Run(ctx, onError)
+}
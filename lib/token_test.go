@@ -0,0 +1,129 @@
+package lib_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func TestTokenExchange(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+
+	lic := lib.NewLicense("Chathura Colombage", time.Now().Add(30*24*time.Hour))
+	if err := lic.Sign(pkey); err != nil {
+		t.Fatal("Failed to sign license:", err)
+	}
+
+	srv := httptest.NewServer(&lib.TokenHandler{
+		PublicKey:  &pkey.PublicKey,
+		PrivateKey: pkey,
+		TTL:        time.Hour,
+	})
+	defer srv.Close()
+
+	cache := filepath.Join(t.TempDir(), "token.json")
+	client := &lib.TokenClient{URL: srv.URL, License: lic, PublicKey: &pkey.PublicKey, CacheFile: cache}
+
+	if err := client.Valid(); err != lib.ErrNoToken {
+		t.Error("Expected ErrNoToken, but found", err)
+	}
+
+	tok, err := client.Refresh(context.Background())
+	if err != nil {
+		t.Fatal("Token refresh failed:", err)
+	}
+	if tok.Info.LicenseID != lic.Info.ID {
+		t.Error("Token license ID does not match!")
+	}
+	if tok.Info.Expiration.Sub(tok.Info.IssuedAt) != time.Hour {
+		t.Error("Unexpected token lifetime:", tok.Info.Expiration.Sub(tok.Info.IssuedAt))
+	}
+
+	// a new client must be able to run offline from the cache
+	offline := &lib.TokenClient{URL: "http://127.0.0.1:0", License: lic, PublicKey: &pkey.PublicKey, CacheFile: cache}
+	if err := offline.LoadCache(); err != nil {
+		t.Fatal("Failed to load cached token:", err)
+	}
+	if err := offline.Valid(); err != nil {
+		t.Error("Expected cached token to be valid, but found", err)
+	}
+}
+
+// JWT and COSE licenses carry their signature in the token or message they
+// were read from, so they must reach the server in that form
+func TestTokenExchangeSourceFormat(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal("Failed to generate Ed25519 key:", err)
+	}
+
+	lic := lib.NewLicense("Chathura Colombage", time.Now().Add(30*24*time.Hour))
+	token, err := lic.JWT(pkey)
+	if err != nil {
+		t.Fatal("Failed to encode JWT license:", err)
+	}
+	cose, err := lic.COSE(priv)
+	if err != nil {
+		t.Fatal("Failed to encode COSE license:", err)
+	}
+
+	srv := httptest.NewServer(&lib.TokenHandler{
+		PublicKey:  &pkey.PublicKey,
+		PrivateKey: pkey,
+		Options:    []lib.VerifyOption{lib.WithEd25519Key(pub)},
+	})
+	defer srv.Close()
+
+	for name, data := range map[string][]byte{"jwt": []byte(token), "cose": cose} {
+		read, err := lib.ReadLicense(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Failed to read %s license: %v", name, err)
+		}
+
+		client := &lib.TokenClient{URL: srv.URL, License: read, PublicKey: &pkey.PublicKey}
+		tok, err := client.Refresh(context.Background())
+		if err != nil {
+			t.Errorf("Token refresh with a %s license failed: %v", name, err)
+			continue
+		}
+		if tok.Info.LicenseID != lic.Info.ID {
+			t.Errorf("Token license ID of the %s license does not match!", name)
+		}
+	}
+}
+
+func TestTokenHandlerRejectsTamperedLicense(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+
+	lic := lib.NewLicense("Chathura Colombage", time.Now().Add(30*24*time.Hour))
+	if err := lic.Sign(pkey); err != nil {
+		t.Fatal("Failed to sign license:", err)
+	}
+	lic.Info.Expiration = lic.Info.Expiration.AddDate(10, 0, 0)
+
+	srv := httptest.NewServer(&lib.TokenHandler{PublicKey: &pkey.PublicKey, PrivateKey: pkey})
+	defer srv.Close()
+
+	client := &lib.TokenClient{URL: srv.URL, License: lic, PublicKey: &pkey.PublicKey}
+	if _, err := client.Refresh(context.Background()); err == nil {
+		t.Error("Expected refresh with a tampered license to fail")
+	}
+}
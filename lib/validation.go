@@ -0,0 +1,132 @@
+package lib
+
+import (
+	"context"
+	"crypto/rsa"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Validation failure codes. They are stable and meant to be matched on by
+// programs, unlike error messages.
+const (
+	CodeReadError             = "read_error"
+	CodeBadSignature          = "bad_signature"
+	CodeExpired               = "expired"
+	CodeNotYetValid           = "not_yet_valid"
+	CodeRevoked               = "revoked"
+	CodeWrongProduct          = "wrong_product"
+	CodeInvalidRevocationList = "invalid_revocation_list"
+)
+
+// ValidationError - A single reason a license failed validation. Code says
+// what is wrong, Field names the license field or input at fault and Err is
+// the underlying cause.
+//
+// errors.Is matches the sentinel errors of the failure (such as
+// ExpiredLicense or InvalidLicense) as well as the cause, and a target
+// *ValidationError with the same Code.
+type ValidationError struct {
+	Code  string
+	Field string
+	Err   error
+
+	// kind is the sentinel error of the failure, if any
+	kind error
+}
+
+func newValidationError(code, field string, kind, cause error) *ValidationError {
+	if cause == nil {
+		cause = kind
+	}
+	return &ValidationError{Code: code, Field: field, Err: cause, kind: kind}
+}
+
+func (e *ValidationError) Error() string {
+	if e.kind != nil && e.Err != e.kind {
+		return fmt.Sprintf("%s: %s", e.kind, e.Err)
+	}
+	return e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the sentinel error of e or a *ValidationError
+// with the same code
+func (e *ValidationError) Is(target error) bool {
+	if t, ok := target.(*ValidationError); ok {
+		return t.Code == e.Code && (t.Field == "" || t.Field == e.Field)
+	}
+	return e.kind != nil && target == e.kind
+}
+
+// ValidationReport - All the problems found validating a license
+type ValidationReport struct {
+	License  *LicenseData
+	Problems []*ValidationError
+}
+
+func (r *ValidationReport) add(err *ValidationError) {
+	r.Problems = append(r.Problems, err)
+}
+
+// OK reports whether the license passed every check
+func (r *ValidationReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Has reports whether a problem with the given code was found
+func (r *ValidationReport) Has(code string) bool {
+	for _, p := range r.Problems {
+		if p.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns the first problem found, or nil when the license is valid
+func (r *ValidationReport) Err() error {
+	if r.OK() {
+		return nil
+	}
+	return r.Problems[0]
+}
+
+func (r *ValidationReport) String() string {
+	if r.OK() {
+		return "License OK"
+	}
+
+	msgs := make([]string, len(r.Problems))
+	for i, p := range r.Problems {
+		msgs[i] = p.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// checkInfo returns the problems with the license information at now. The
+// license is not expired until grace has passed after its expiry.
+func (lic *LicenseData) checkInfo(now time.Time, grace time.Duration) []*ValidationError {
+	var problems []*ValidationError
+
+	if now.After(lic.Info.Expiration.Add(grace)) {
+		problems = append(problems, newValidationError(CodeExpired, "expiration", ExpiredLicense, nil))
+	}
+
+	if lic.Info.NotBefore != nil && now.Before(*lic.Info.NotBefore) {
+		problems = append(problems, newValidationError(CodeNotYetValid, "not_before", ErrLicenseNotYetValid, nil))
+	}
+
+	return problems
+}
+
+// ValidateLicense runs every check on lic and reports all problems found
+// instead of stopping at the first one
+func ValidateLicense(lic *LicenseData, publicKey *rsa.PublicKey, opts ...VerifyOption) *ValidationReport {
+	opts = append([]VerifyOption{WithPublicKey(publicKey)}, opts...)
+	return NewVerifier(opts...).Validate(context.Background(), lic)
+}
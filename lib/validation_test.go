@@ -0,0 +1,47 @@
+package lib_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func TestValidationReport(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+
+	lic := lib.NewLicense("Chathura Colombage", time.Now().Add(-24*time.Hour))
+	if err := lic.Sign(pkey); err != nil {
+		t.Fatal("Failed to sign license:", err)
+	}
+	lic.Info.Name = "Someone Else"
+
+	report := lib.ValidateLicense(lic, &pkey.PublicKey)
+	if report.OK() || len(report.Problems) != 2 {
+		t.Fatal("Expected 2 problems, but found", report.Problems)
+	}
+	if !report.Has(lib.CodeBadSignature) || !report.Has(lib.CodeExpired) {
+		t.Error("Expected bad signature and expiry, but found", report)
+	}
+
+	err = report.Err()
+	if !errors.Is(err, lib.InvalidLicense) {
+		t.Error("Expected InvalidLicense, but found", err)
+	}
+	if !errors.Is(err, &lib.ValidationError{Code: lib.CodeBadSignature}) {
+		t.Error("Expected a bad signature error, but found", err)
+	}
+
+	var verr *lib.ValidationError
+	if !errors.As(report.Problems[1], &verr) || verr.Field != "expiration" {
+		t.Error("Expected a ValidationError for the expiration, but found", report.Problems[1])
+	}
+	if !errors.Is(verr, lib.ExpiredLicense) || errors.Is(verr, lib.InvalidLicense) {
+		t.Error("Expected only ExpiredLicense to match, but found", verr)
+	}
+}
@@ -0,0 +1,408 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Verifier errors
+var (
+	ErrNoTrustedKeys    = errors.New("No trusted public keys configured")
+	ErrUntrustedKey     = errors.New("License is not signed by a trusted key")
+	ErrInvalidPublicKey = errors.New("Not a PEM encoded RSA or Ed25519 public key")
+)
+
+// trustedKey is a public key with its precomputed identifiers. Exactly one of
+// key and edKey is set.
+type trustedKey struct {
+	id          string
+	fingerprint string
+	key         *rsa.PublicKey
+	edKey       ed25519.PublicKey
+}
+
+func newTrustedKey(key *rsa.PublicKey) (trustedKey, error) {
+	id, err := KeyID(key)
+	if err != nil {
+		return trustedKey{}, err
+	}
+	fp, err := Fingerprint(key)
+	if err != nil {
+		return trustedKey{}, err
+	}
+	return trustedKey{id: id, fingerprint: fp, key: key}, nil
+}
+
+func newEd25519TrustedKey(key ed25519.PublicKey) (trustedKey, error) {
+	id, err := Ed25519KeyID(key)
+	if err != nil {
+		return trustedKey{}, err
+	}
+	fp, err := pkixFingerprint(key)
+	if err != nil {
+		return trustedKey{}, err
+	}
+	return trustedKey{id: id, fingerprint: fp, edKey: key}, nil
+}
+
+// verify checks the signature of lic with the key. COSE licenses are signed
+// with Ed25519 keys and every other format with RSA keys.
+func (k trustedKey) verify(lic *LicenseData) error {
+	if lic.cose != nil {
+		if k.edKey == nil {
+			return ErrCOSEKeyType
+		}
+		return lic.ValidateCOSE(k.edKey)
+	}
+	return lic.ValidateLicenseKeyWithPublicKey(k.key)
+}
+
+// TrustStore - The set of public keys licenses may be signed with: RSA keys
+// for licenses and Ed25519 keys for COSE licenses. It is safe for concurrent
+// use.
+type TrustStore struct {
+	mu   sync.RWMutex
+	keys []trustedKey
+}
+
+// NewTrustStore returns a trust store holding keys
+func NewTrustStore(keys ...*rsa.PublicKey) (*TrustStore, error) {
+	ts := &TrustStore{}
+	for _, key := range keys {
+		if err := ts.Add(key); err != nil {
+			return nil, err
+		}
+	}
+	return ts, nil
+}
+
+// Add trusts another public key
+func (ts *TrustStore) Add(key *rsa.PublicKey) error {
+	k, err := newTrustedKey(key)
+	if err != nil {
+		return err
+	}
+	ts.add(k)
+	return nil
+}
+
+// AddEd25519 trusts another Ed25519 public key, which verifies COSE licenses
+func (ts *TrustStore) AddEd25519(key ed25519.PublicKey) error {
+	k, err := newEd25519TrustedKey(key)
+	if err != nil {
+		return err
+	}
+	ts.add(k)
+	return nil
+}
+
+// AddPEM trusts the PEM encoded RSA or Ed25519 public key in data, such as
+// cert.pem or product-key.pub.pem
+func (ts *TrustStore) AddPEM(data []byte) error {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return ErrInvalidPublicKey
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return ts.Add(key)
+	case ed25519.PublicKey:
+		return ts.AddEd25519(key)
+	}
+	return ErrInvalidPublicKey
+}
+
+func (ts *TrustStore) add(key trustedKey) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for _, k := range ts.keys {
+		if k.id == key.id {
+			return
+		}
+	}
+	ts.keys = append(ts.keys, key)
+}
+
+// Lookup returns the trusted RSA key with the given KeyID, or nil
+func (ts *TrustStore) Lookup(keyID string) *rsa.PublicKey {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	for _, k := range ts.keys {
+		if k.id == keyID && k.key != nil {
+			return k.key
+		}
+	}
+	return nil
+}
+
+// Len returns the number of trusted keys
+func (ts *TrustStore) Len() int {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return len(ts.keys)
+}
+
+func (ts *TrustStore) snapshot() []trustedKey {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return append([]trustedKey(nil), ts.keys...)
+}
+
+// WithTrustStore verifies licenses against the keys of ts
+func WithTrustStore(ts *TrustStore) VerifyOption {
+	return func(o *verifyOptions) {
+		o.trust = append(o.trust, ts)
+	}
+}
+
+// WithPublicKey verifies licenses against a single public key
+func WithPublicKey(key *rsa.PublicKey) VerifyOption {
+	return func(o *verifyOptions) {
+		o.keys = append(o.keys, key)
+	}
+}
+
+// WithEd25519Key verifies COSE licenses against a single Ed25519 public key,
+// the product_public_key of lgen
+func WithEd25519Key(key ed25519.PublicKey) VerifyOption {
+	return func(o *verifyOptions) {
+		o.edKeys = append(o.edKeys, key)
+	}
+}
+
+// WithClock sets the source of the current time, for testing or for
+// applications with a trusted time source
+func WithClock(now func() time.Time) VerifyOption {
+	return func(o *verifyOptions) {
+		o.clock = now
+	}
+}
+
+// WithRequiredProduct rejects licenses issued for any other product
+func WithRequiredProduct(product string) VerifyOption {
+	return func(o *verifyOptions) {
+		o.product = product
+	}
+}
+
+// WithFingerprint only accepts licenses signed by the key with the given
+// fingerprint, as printed by 'lgen inspect'. Colons are optional.
+func WithFingerprint(fingerprint string) VerifyOption {
+	return func(o *verifyOptions) {
+		o.fingerprint = normalizeFingerprint(fingerprint)
+	}
+}
+
+// WithGracePeriod keeps accepting licenses for grace after they expire
+func WithGracePeriod(grace time.Duration) VerifyOption {
+	return func(o *verifyOptions) {
+		o.grace = grace
+	}
+}
+
+func normalizeFingerprint(fp string) string {
+	return strings.ToLower(strings.Replace(fp, ":", "", -1))
+}
+
+// VerifiedLicense - A license that passed every check of a Verifier
+type VerifiedLicense struct {
+	LicenseInfo
+
+	// KeyID and Fingerprint identify the key the license was verified with
+	KeyID       string
+	Fingerprint string
+
+	// Status at the time of verification. It is StatusGrace when the license
+	// is only accepted because of the grace period.
+	Status LicenseStatus
+
+	License *LicenseData
+}
+
+// Verifier checks licenses against a policy set up once with options. It is
+// safe for concurrent use.
+type Verifier struct {
+	opts verifyOptions
+}
+
+// NewVerifier returns a Verifier with the given options. At least one of
+// WithTrustStore, WithPublicKey or WithEd25519Key is needed for licenses to
+// verify.
+func NewVerifier(opts ...VerifyOption) *Verifier {
+	v := &Verifier{opts: verifyOptions{crl: &revocationCache{}}}
+	for _, opt := range opts {
+		opt(&v.opts)
+	}
+	return v
+}
+
+// Verify reads a license from licenseBytes and checks it. On failure the
+// error is the first *ValidationError found.
+func (v *Verifier) Verify(ctx context.Context, licenseBytes []byte) (*VerifiedLicense, error) {
+	lic, err := ReadLicense(bytes.NewReader(licenseBytes))
+	if err != nil {
+		return nil, newValidationError(CodeReadError, "license", ErrorLicenseRead, err)
+	}
+
+	return v.VerifyLicense(ctx, lic)
+}
+
+// VerifyLicense checks a license that has already been read
+func (v *Verifier) VerifyLicense(ctx context.Context, lic *LicenseData) (*VerifiedLicense, error) {
+	report, key := v.validate(ctx, lic)
+	if err := report.Err(); err != nil {
+		return nil, err
+	}
+
+	return &VerifiedLicense{
+		LicenseInfo: lic.Info,
+		KeyID:       key.id,
+		Fingerprint: key.fingerprint,
+		Status:      lic.Status(v.opts.now(), v.opts.grace),
+		License:     lic,
+	}, nil
+}
+
+// Status returns the state of lic at the current time of the verifier, with
+// its grace period. The error is the *ValidationError of a license that is
+// expired past the grace period or not yet valid. Signatures and revocation
+// are not checked again.
+func (v *Verifier) Status(lic *LicenseData) (LicenseStatus, error) {
+	now := v.opts.now()
+	st := lic.Status(now, v.opts.grace)
+	if problems := lic.checkInfo(now, v.opts.grace); len(problems) > 0 {
+		return st, problems[0]
+	}
+	return st, nil
+}
+
+// Validate checks lic and reports every problem found
+func (v *Verifier) Validate(ctx context.Context, lic *LicenseData) *ValidationReport {
+	report, _ := v.validate(ctx, lic)
+	return report
+}
+
+func (v *Verifier) validate(ctx context.Context, lic *LicenseData) (*ValidationReport, trustedKey) {
+	report := &ValidationReport{License: lic}
+
+	if err := ctx.Err(); err != nil {
+		report.add(newValidationError(CodeReadError, "", nil, err))
+		return report, trustedKey{}
+	}
+
+	key, err := v.opts.signingKey(lic)
+	if err != nil {
+		report.add(err)
+	} else if err := v.opts.checkRevocation(ctx, lic, key); err != nil {
+		report.add(err)
+	}
+
+	if v.opts.product != "" && lic.Info.Product != v.opts.product {
+		report.add(newValidationError(CodeWrongProduct, "product", ErrWrongProduct, nil))
+	}
+
+	for _, p := range lic.checkInfo(v.opts.now(), v.opts.grace) {
+		report.add(p)
+	}
+
+	return report, key
+}
+
+func (o *verifyOptions) now() time.Time {
+	if o.clock != nil {
+		return o.clock()
+	}
+	return time.Now()
+}
+
+// trustedKeys returns every key of the trust stores and options
+func (o *verifyOptions) trustedKeys() ([]trustedKey, error) {
+	var keys []trustedKey
+	for _, ts := range o.trust {
+		keys = append(keys, ts.snapshot()...)
+	}
+	for _, key := range o.keys {
+		k, err := newTrustedKey(key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	for _, key := range o.edKeys {
+		k, err := newEd25519TrustedKey(key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// candidates returns the trusted keys that may have signed lic
+func (o *verifyOptions) candidates(lic *LicenseData) ([]trustedKey, error) {
+	all, err := o.trustedKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []trustedKey
+	for _, k := range all {
+		if (k.edKey != nil) != (lic.cose != nil) {
+			continue
+		}
+		if o.fingerprint != "" && normalizeFingerprint(k.fingerprint) != o.fingerprint {
+			continue
+		}
+		keys = append(keys, k)
+	}
+
+	// licenses signed before key IDs were recorded are tried with every key
+	if lic.KeyID == "" {
+		return keys, nil
+	}
+
+	for _, k := range keys {
+		if k.id == lic.KeyID {
+			return []trustedKey{k}, nil
+		}
+	}
+	return nil, nil
+}
+
+// signingKey returns the trusted key lic is signed with
+func (o *verifyOptions) signingKey(lic *LicenseData) (trustedKey, *ValidationError) {
+	if len(o.trust) == 0 && len(o.keys) == 0 && len(o.edKeys) == 0 {
+		return trustedKey{}, newValidationError(CodeBadSignature, "key", InvalidLicense, ErrNoTrustedKeys)
+	}
+
+	keys, err := o.candidates(lic)
+	if err != nil {
+		return trustedKey{}, newValidationError(CodeBadSignature, "key", InvalidLicense, err)
+	}
+	if len(keys) == 0 {
+		return trustedKey{}, newValidationError(CodeBadSignature, "key_id", InvalidLicense, ErrUntrustedKey)
+	}
+
+	var lastErr error
+	for _, k := range keys {
+		if lastErr = k.verify(lic); lastErr == nil {
+			return k, nil
+		}
+	}
+
+	// we have a key mismatch here meaning license data is tampered
+	return trustedKey{}, newValidationError(CodeBadSignature, "key", InvalidLicense, lastErr)
+}
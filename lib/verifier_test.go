@@ -0,0 +1,80 @@
+package lib_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func TestVerifier(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal("Failed to generate key:", err)
+	}
+
+	expiry := time.Date(2030, 1, 31, 0, 0, 0, 0, time.UTC)
+	lic := lib.NewLicense("Chathura Colombage", expiry)
+	lic.Info.Product = "app"
+	if err := lic.Sign(pkey); err != nil {
+		t.Fatal("Failed to sign license:", err)
+	}
+	var buf bytes.Buffer
+	lic.WriteLicense(&buf)
+
+	ts, err := lib.NewTrustStore(&other.PublicKey, &pkey.PublicKey)
+	if err != nil {
+		t.Fatal("Failed to create trust store:", err)
+	}
+	fp, _ := lib.Fingerprint(&pkey.PublicKey)
+
+	now := expiry.Add(-time.Hour)
+	clock := func() time.Time { return now }
+	v := lib.NewVerifier(lib.WithTrustStore(ts), lib.WithClock(clock),
+		lib.WithRequiredProduct("app"), lib.WithFingerprint(fp), lib.WithGracePeriod(48*time.Hour))
+
+	ctx := context.Background()
+	vl, err := v.Verify(ctx, buf.Bytes())
+	if err != nil {
+		t.Fatal("Expected nil error, but found", err)
+	}
+	if vl.Name != "Chathura Colombage" || vl.KeyID != lic.KeyID || vl.Status.Status != lib.StatusValid {
+		t.Error("Unexpected verified license", vl)
+	}
+
+	now = expiry.Add(24 * time.Hour)
+	if vl, err := v.Verify(ctx, buf.Bytes()); err != nil || vl.Status.Status != lib.StatusGrace {
+		t.Error("Expected license in grace period, but found", err)
+	}
+
+	now = expiry.Add(72 * time.Hour)
+	if _, err := v.Verify(ctx, buf.Bytes()); !errors.Is(err, lib.ExpiredLicense) {
+		t.Error("Expected ExpiredLicense, but found", err)
+	}
+
+	now = expiry.Add(-time.Hour)
+	wrongProduct := lib.NewVerifier(lib.WithPublicKey(&pkey.PublicKey), lib.WithClock(clock), lib.WithRequiredProduct("other"))
+	if _, err := wrongProduct.Verify(ctx, buf.Bytes()); !errors.Is(err, lib.ErrWrongProduct) {
+		t.Error("Expected ErrWrongProduct, but found", err)
+	}
+
+	otherFP, _ := lib.Fingerprint(&other.PublicKey)
+	pinned := lib.NewVerifier(lib.WithTrustStore(ts), lib.WithClock(clock), lib.WithFingerprint(otherFP))
+	if _, err := pinned.Verify(ctx, buf.Bytes()); !errors.Is(err, lib.ErrUntrustedKey) {
+		t.Error("Expected ErrUntrustedKey, but found", err)
+	}
+
+	if _, err := lib.NewVerifier().Verify(ctx, buf.Bytes()); !errors.Is(err, lib.ErrNoTrustedKeys) {
+		t.Error("Expected ErrNoTrustedKeys, but found", err)
+	}
+}
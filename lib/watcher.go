@@ -0,0 +1,279 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// Watcher defaults
+const (
+	DefaultWatchPollInterval  = 10 * time.Second
+	DefaultWatchCheckInterval = time.Hour
+	DefaultExpiringSoon       = 30 * 24 * time.Hour
+)
+
+// Watched license states
+const (
+	watchUnknown = iota
+	watchValid
+	watchExpiringSoon
+	watchExpired
+	watchInvalid
+)
+
+// Watcher keeps checking a license file in the background of a long running
+// service. The file is polled for changes and the license is re-validated on a
+// schedule, so expiry and replacement are noticed without a restart.
+//
+// Callbacks are invoked from the goroutine running the watcher when the state
+// of the license changes, not on every check. All of them are optional.
+type Watcher struct {
+	// Path of the license file
+	Path string
+	// Verifier the license is checked with
+	Verifier *Verifier
+	// PollInterval is how often the file is checked for changes. Defaults to
+	// DefaultWatchPollInterval.
+	PollInterval time.Duration
+	// CheckInterval is how often an unchanged license is re-validated.
+	// Defaults to DefaultWatchCheckInterval. The license is also checked as
+	// soon as it is due to expire or become expiring soon.
+	CheckInterval time.Duration
+	// ExpiringSoon is how long before expiry OnExpiringSoon is invoked instead
+	// of OnValid. Defaults to DefaultExpiringSoon.
+	ExpiringSoon time.Duration
+
+	// OnValid is invoked when the license becomes valid
+	OnValid func(*VerifiedLicense)
+	// OnExpiringSoon is invoked when the license is valid but expires within
+	// ExpiringSoon, or is only accepted because of a grace period
+	OnExpiringSoon func(*VerifiedLicense)
+	// OnExpired is invoked when the license expires
+	OnExpired func(lic *LicenseData, err error)
+	// OnReplaced is invoked when the file holds a different license than at
+	// the previous check, before the new license is validated
+	OnReplaced func(old, new *LicenseData)
+
+	mu      sync.RWMutex
+	current *VerifiedLicense
+	lic     *LicenseData
+	state   int
+	hash    [sha256.Size]byte
+	modTime time.Time
+	size    int64
+	nextDue time.Time
+	err     error
+}
+
+// License returns the license from the last successful check, or nil when
+// the license is not valid
+func (w *Watcher) License() *VerifiedLicense {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.current
+}
+
+// Err returns why the license was rejected at the last check, or nil when it
+// is valid
+func (w *Watcher) Err() error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.err
+}
+
+// Check reads and validates the license file now. Errors other than expiry,
+// such as a missing file or a bad signature, are returned.
+func (w *Watcher) Check(ctx context.Context) error {
+	return w.update(ctx, true)
+}
+
+// update checks the license, if forced or due, and then invokes the callbacks
+// of the state changes found. Callbacks run without the lock held so that
+// they may call License.
+func (w *Watcher) update(ctx context.Context, force bool) error {
+	var events []func()
+
+	w.mu.Lock()
+	var err error
+	if force || w.due() {
+		err = w.check(ctx, &events)
+		w.err = err
+		if w.state == watchExpired {
+			w.err = newValidationError(CodeExpired, "expiration", ExpiredLicense, nil)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, event := range events {
+		event()
+	}
+
+	return err
+}
+
+// check reads and validates the license, appending the callbacks to invoke
+// to events
+func (w *Watcher) check(ctx context.Context, events *[]func()) error {
+	now := w.Verifier.opts.now()
+
+	info, err := os.Stat(w.Path)
+	if err != nil {
+		w.setState(watchInvalid, nil)
+		return err
+	}
+	data, err := ioutil.ReadFile(w.Path)
+	if err != nil {
+		w.setState(watchInvalid, nil)
+		return err
+	}
+	w.modTime, w.size = info.ModTime(), info.Size()
+
+	hash := sha256.Sum256(data)
+	changed := hash != w.hash
+	w.hash = hash
+
+	lic := w.lic
+	if changed || lic == nil {
+		if lic, err = ReadLicense(bytes.NewReader(data)); err != nil {
+			w.setState(watchInvalid, nil)
+			return newValidationError(CodeReadError, "license", ErrorLicenseRead, err)
+		}
+
+		if old := w.lic; old != nil && !sameLicense(old, lic) && w.OnReplaced != nil {
+			*events = append(*events, func() { w.OnReplaced(old, lic) })
+		}
+		w.lic = lic
+	}
+
+	w.nextDue = now.Add(w.checkInterval())
+
+	vl, err := w.Verifier.VerifyLicense(ctx, lic)
+	if err != nil {
+		if errors.Is(err, ExpiredLicense) {
+			if w.state != watchExpired && w.OnExpired != nil {
+				*events = append(*events, func() { w.OnExpired(lic, err) })
+			}
+			w.setState(watchExpired, nil)
+			return nil
+		}
+
+		w.setState(watchInvalid, nil)
+		return err
+	}
+
+	expiresIn := vl.Expiration.Sub(now)
+	if vl.Status.Status == StatusGrace || expiresIn <= w.expiringSoon() {
+		if w.state != watchExpiringSoon && w.OnExpiringSoon != nil {
+			*events = append(*events, func() { w.OnExpiringSoon(vl) })
+		}
+		w.setState(watchExpiringSoon, vl)
+	} else {
+		if w.state != watchValid && w.OnValid != nil {
+			*events = append(*events, func() { w.OnValid(vl) })
+		}
+		w.setState(watchValid, vl)
+	}
+
+	// check again when the next state change is due
+	boundary := vl.Expiration
+	if vl.Status.GraceUntil != nil {
+		boundary = *vl.Status.GraceUntil
+	}
+	if soon := vl.Expiration.Add(-w.expiringSoon()); soon.After(now) {
+		boundary = soon
+	}
+	if boundary.Before(w.nextDue) {
+		w.nextDue = boundary.Add(time.Second)
+	}
+
+	return nil
+}
+
+// sameLicense reports whether a and b hold the same license information.
+// Signatures are not compared: the same license converted to another format,
+// such as from JSON to a JWT, has another signature.
+func sameLicense(a, b *LicenseData) bool {
+	if a.Info.ID != b.Info.ID {
+		return false
+	}
+	pa, err := a.CanonicalPayload()
+	if err != nil {
+		return false
+	}
+	pb, err := b.CanonicalPayload()
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(pa, pb)
+}
+
+func (w *Watcher) setState(state int, vl *VerifiedLicense) {
+	w.state = state
+	w.current = vl
+}
+
+// due reports whether the file looks different from the last check or a
+// scheduled check is due
+func (w *Watcher) due() bool {
+	info, err := os.Stat(w.Path)
+	if err != nil {
+		return w.state != watchInvalid
+	}
+	if !info.ModTime().Equal(w.modTime) || info.Size() != w.size {
+		return true
+	}
+	return !w.Verifier.opts.now().Before(w.nextDue)
+}
+
+func (w *Watcher) pollInterval() time.Duration {
+	if w.PollInterval > 0 {
+		return w.PollInterval
+	}
+	return DefaultWatchPollInterval
+}
+
+func (w *Watcher) checkInterval() time.Duration {
+	if w.CheckInterval > 0 {
+		return w.CheckInterval
+	}
+	return DefaultWatchCheckInterval
+}
+
+func (w *Watcher) expiringSoon() time.Duration {
+	if w.ExpiringSoon > 0 {
+		return w.ExpiringSoon
+	}
+	return DefaultExpiringSoon
+}
+
+// Run checks the license until ctx is done. Check errors are passed to
+// onError, which may be nil.
+func (w *Watcher) Run(ctx context.Context, onError func(error)) {
+	ticker := time.NewTicker(w.pollInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.update(ctx, false); err != nil && ctx.Err() == nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// Start checks the license once and then keeps watching it in a new
+// goroutine. The error of the first check is returned.
+func (w *Watcher) Start(ctx context.Context, onError func(error)) error {
+	err := w.Check(ctx)
+	go w.Run(ctx, onError)
+	return err
+}
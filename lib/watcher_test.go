@@ -0,0 +1,142 @@
+package lib_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dewaka/license_gen/lib"
+)
+
+func TestWatcher(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+
+	dir, err := ioutil.TempDir("", "watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "license.json")
+
+	expiry := time.Date(2030, 1, 31, 0, 0, 0, 0, time.UTC)
+	lic := lib.NewLicense("Chathura Colombage", expiry)
+	if err := lic.Sign(pkey); err != nil {
+		t.Fatal("Failed to sign license:", err)
+	}
+	if err := lic.SaveLicenseToFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	now := expiry.Add(-60 * 24 * time.Hour)
+	verifier := lib.NewVerifier(lib.WithPublicKey(&pkey.PublicKey), lib.WithClock(func() time.Time { return now }))
+
+	var events []string
+	w := &lib.Watcher{
+		Path:           path,
+		Verifier:       verifier,
+		OnValid:        func(*lib.VerifiedLicense) { events = append(events, "valid") },
+		OnExpiringSoon: func(*lib.VerifiedLicense) { events = append(events, "expiring") },
+		OnExpired:      func(*lib.LicenseData, error) { events = append(events, "expired") },
+		OnReplaced:     func(old, new *lib.LicenseData) { events = append(events, "replaced") },
+	}
+
+	ctx := context.Background()
+	check := func() {
+		if err := w.Check(ctx); err != nil {
+			t.Fatal("Check failed:", err)
+		}
+	}
+
+	check()
+	check()
+	if w.License() == nil || w.License().ID != lic.Info.ID {
+		t.Error("Expected the current license, but found", w.License())
+	}
+
+	now = expiry.Add(-10 * 24 * time.Hour)
+	check()
+
+	now = expiry.Add(time.Hour)
+	check()
+	if w.License() != nil {
+		t.Error("Expected no license after expiry, but found", w.License())
+	}
+
+	renewed := lic.Renew(expiry.AddDate(1, 0, 0))
+	if err := renewed.Sign(pkey); err != nil {
+		t.Fatal("Failed to sign license:", err)
+	}
+	if err := renewed.SaveLicenseToFile(path); err != nil {
+		t.Fatal(err)
+	}
+	check()
+
+	want := "valid expiring expired replaced valid"
+	if got := strings.Join(events, " "); got != want {
+		t.Errorf("Expected events %q, but found %q", want, got)
+	}
+}
+
+func TestWatcherReplacedJWT(t *testing.T) {
+	pkey, err := lib.ReadPrivateKey(strings.NewReader(privKey))
+	if err != nil {
+		t.Fatal("Failed to read private key:", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "license")
+	writeJWT := func(lic *lib.LicenseData) {
+		token, err := lic.JWT(pkey)
+		if err != nil {
+			t.Fatal("Failed to encode JWT license:", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(token+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expiry := time.Date(2030, 1, 31, 0, 0, 0, 0, time.UTC)
+	lic := lib.NewLicense("Chathura Colombage", expiry)
+	if err := lic.Sign(pkey); err != nil {
+		t.Fatal("Failed to sign license:", err)
+	}
+	if err := lic.SaveLicenseToFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	now := expiry.Add(-60 * 24 * time.Hour)
+	var events []string
+	w := &lib.Watcher{
+		Path:       path,
+		Verifier:   lib.NewVerifier(lib.WithPublicKey(&pkey.PublicKey), lib.WithClock(func() time.Time { return now })),
+		OnReplaced: func(old, new *lib.LicenseData) { events = append(events, "replaced") },
+	}
+
+	ctx := context.Background()
+	check := func() {
+		if err := w.Check(ctx); err != nil {
+			t.Fatal("Check failed:", err)
+		}
+	}
+
+	check()
+
+	// the same license converted to a JWT is not a replacement
+	writeJWT(lic)
+	check()
+	if len(events) != 0 {
+		t.Error("Expected no events for a converted license, but found", events)
+	}
+
+	writeJWT(lic.Renew(expiry.AddDate(1, 0, 0)))
+	check()
+	if got := strings.Join(events, " "); got != "replaced" {
+		t.Errorf("Expected events %q, but found %q", "replaced", got)
+	}
+}